@@ -0,0 +1,148 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// $pipe [_char:x] command1 | command2 | ...
+// the output of each command is added to the arguments of the next one
+func pipeCommand(inv *Invocation) (string, error) {
+	char := "|"
+	text := inv.Text
+	if strings.HasPrefix(text, "_char:") {
+		end := wordEnd(text, 0)
+		char = text[len("_char:"):end]
+		text = text[end:]
+		if char == "" {
+			return "", errors.New("pipe: _char cannot be empty")
+		}
+	}
+	output := ""
+	for i, line := range strings.Split(text, char) {
+		line = strings.TrimSpace(line)
+		if output != "" {
+			line += " " + output
+		}
+		var err error
+		output, err = inv.Execute(line)
+		if err != nil {
+			return "", fmt.Errorf("pipe command %d (%s): %w", i+1, line, err)
+		}
+	}
+	return output, nil
+}
+
+// $abb say text, other sub commands must be registered as "abb name"
+func abbCommand(inv *Invocation) (string, error) {
+	sub, text := splitCommand(inv.Text)
+	switch strings.ToLower(sub) {
+	case "say", "echo":
+		return text, nil
+	case "":
+		return "", errors.New("abb: no sub command")
+	}
+	command := inv.Emulator.commands["abb "+strings.ToLower(sub)]
+	if command == nil {
+		return "", fmt.Errorf("abb: unknown sub command: %s", sub)
+	}
+	child := *inv
+	child.Command = "abb " + sub
+	child.Text = text
+	return command(&child)
+}
+
+func nullCommand(inv *Invocation) (string, error) {
+	return "", nil
+}
+
+// $alias add/create/edit/addedit/upsert/remove/run/try
+func aliasCommand(inv *Invocation) (string, error) {
+	sub, text := splitCommand(inv.Text)
+	user := inv.Emulator.User(inv.Executor)
+	sub = strings.ToLower(sub)
+	switch sub {
+	case "add", "create", "edit", "addedit", "upsert":
+		name, definition := splitCommand(text)
+		if name == "" || definition == "" {
+			return "", fmt.Errorf("alias %s: an alias needs a name and a command", sub)
+		}
+		_, exists := user.Aliases[name]
+		if exists && (sub == "add" || sub == "create") {
+			return "", fmt.Errorf("alias %s: alias %s already exists", sub, name)
+		}
+		if !exists && sub == "edit" {
+			return "", fmt.Errorf("alias %s: alias %s does not exist", sub, name)
+		}
+		user.Aliases[name] = definition
+		return fmt.Sprintf("Alias %s set successfully.", name), nil
+	case "remove", "delete":
+		name, _ := splitCommand(text)
+		if _, exists := user.Aliases[name]; !exists {
+			return "", fmt.Errorf("alias %s: alias %s does not exist", sub, name)
+		}
+		delete(user.Aliases, name)
+		return fmt.Sprintf("Alias %s removed successfully.", name), nil
+	case "run":
+		name, args := splitCommand(text)
+		return inv.runAlias(inv.Executor, name, strings.Fields(args))
+	case "try":
+		owner, rest := splitCommand(text)
+		name, args := splitCommand(rest)
+		return inv.runAlias(owner, name, strings.Fields(args))
+	default:
+		return "", fmt.Errorf("alias: unknown sub command: %s", sub)
+	}
+}
+
+// $ name args, runs an alias of the executor
+func aliasRunCommand(inv *Invocation) (string, error) {
+	name, args := splitCommand(inv.Text)
+	return inv.runAlias(inv.Executor, name, strings.Fields(args))
+}
+
+// runAlias runs an alias of owner, as the executor of inv
+func (inv *Invocation) runAlias(owner, name string, args []string) (string, error) {
+	if name == "" {
+		return "", errors.New("no alias provided")
+	}
+	definition, ok := inv.Emulator.User(owner).Aliases[name]
+	if !ok {
+		return "", fmt.Errorf("alias %s of %s does not exist", name, owner)
+	}
+	if inv.depth >= inv.Emulator.MaxDepth {
+		return "", fmt.Errorf("alias %s: too many aliases running inside each other (max %d)", name, inv.Emulator.MaxDepth)
+	}
+	child := *inv
+	child.depth++
+	output, err := child.Execute(expandArgs(definition, args, inv.Executor, inv.Channel))
+	if err != nil {
+		return "", fmt.Errorf("alias %s: %w", name, err)
+	}
+	return output, nil
+}
+
+// $js function:"..." [errorInfo:true] [importGist:id] args
+func jsCommand(inv *Invocation) (string, error) {
+	if inv.Emulator.JS == nil {
+		return "", errors.New("js: no javascript engine")
+	}
+	params, args, err := inv.Params("function", "errorInfo", "importGist")
+	if err != nil {
+		return "", fmt.Errorf("js: %w", err)
+	}
+	function, ok := params["function"]
+	if !ok {
+		return "", errors.New("js: no function provided")
+	}
+	return inv.Emulator.JS.Run(&JSContext{
+		Function:   function,
+		Args:       args,
+		Executor:   inv.Executor,
+		Channel:    inv.Channel,
+		ErrorInfo:  params["errorInfo"] == "true",
+		ImportGist: params["importGist"],
+		CustomData: inv.Emulator.User(inv.Executor).CustomData,
+	})
+}
@@ -0,0 +1,165 @@
+// Package emulator runs compiled supibot aliases locally, without supibot.
+//
+// It understands the commands the sbl compiler outputs ($pipe, $abb say, $null,
+// $alias and $js), other commands can be added with Emulator.Register.
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Runs a command, returning its output
+type Command func(inv *Invocation) (string, error)
+
+// Javascript engine used by the js command
+type JSEngine interface {
+	// Run the function, returning the result as text
+	Run(ctx *JSContext) (string, error)
+}
+
+// Everything the js command passes to the javascript engine
+type JSContext struct {
+	// Body of the function parameter
+	Function string
+	// Arguments that are not parameters
+	Args     []string
+	Executor string
+	Channel  string
+	// Return error details instead of a generic error (errorInfo:true)
+	ErrorInfo bool
+	// Gist to load before running the function (importGist:id), empty if there is none
+	ImportGist string
+	// customData of the executor, persisted between executions
+	CustomData map[string]interface{}
+}
+
+// A supibot user, with their aliases and customData
+type User struct {
+	// alias definitions by name, the command followed by its arguments
+	Aliases    map[string]string
+	CustomData map[string]interface{}
+}
+
+type Emulator struct {
+	// Users by name, created when they are first used
+	Users map[string]*User
+	// Executes the js command, js fails if this is nil
+	JS JSEngine
+	// Maximum number of aliases that can be running inside each other
+	MaxDepth int
+	commands map[string]Command
+}
+
+// New returns an emulator with the builtin commands
+func New() *Emulator {
+	e := &Emulator{
+		Users:    make(map[string]*User),
+		MaxDepth: 10,
+		commands: make(map[string]Command),
+	}
+	e.Register("pipe", pipeCommand)
+	e.Register("abb", abbCommand)
+	e.Register("null", nullCommand)
+	e.Register("alias", aliasCommand)
+	e.Register("$", aliasRunCommand)
+	e.Register("js", jsCommand)
+	return e
+}
+
+// Register a command, replacing the command with the same name.
+// Sub commands of abb (like "abb ac") can be registered with their full name.
+func (e *Emulator) Register(name string, command Command) {
+	e.commands[strings.ToLower(name)] = command
+}
+
+// Reply returns a command that always outputs text, to stand in for other commands
+func Reply(text string) Command {
+	return func(inv *Invocation) (string, error) {
+		return text, nil
+	}
+}
+
+// User returns the user with the name, creating them if they do not exist
+func (e *Emulator) User(name string) *User {
+	user := e.Users[name]
+	if user == nil {
+		user = &User{
+			Aliases:    make(map[string]string),
+			CustomData: make(map[string]interface{}),
+		}
+		e.Users[name] = user
+	}
+	return user
+}
+
+// Run a message sent in chat, like "$alias addedit xd ping" or "$$xd lol"
+func (e *Emulator) Run(executor, channel, message string) (string, error) {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "$") {
+		return "", errors.New("a command must start with $")
+	}
+	message = message[1:]
+	// "$$xd" runs the alias xd
+	if strings.HasPrefix(message, "$") && !strings.HasPrefix(message, "$ ") {
+		message = "$ " + message[1:]
+	}
+	inv := &Invocation{Emulator: e, Executor: executor, Channel: channel}
+	return inv.Execute(message)
+}
+
+// A single execution of a command
+type Invocation struct {
+	Emulator *Emulator
+	// User that executed the command
+	Executor string
+	Channel  string
+	// Name of the command
+	Command string
+	// Text after the command name
+	Text string
+	// number of aliases this is running inside
+	depth int
+}
+
+// Args returns the words of Text
+func (inv *Invocation) Args() []string {
+	return strings.Fields(inv.Text)
+}
+
+// Params parses the named parameters (like "function:\"...\"") out of Text,
+// returning the values of the parameters and the words that are not parameters
+func (inv *Invocation) Params(names ...string) (map[string]string, []string, error) {
+	return parseParams(inv.Text, names)
+}
+
+// Execute a command line (without the "$" prefix) in the same context as inv
+func (inv *Invocation) Execute(line string) (string, error) {
+	name, text := splitCommand(line)
+	if name == "" {
+		return "", errors.New("no command")
+	}
+	command := inv.Emulator.commands[strings.ToLower(name)]
+	if command == nil {
+		return "", fmt.Errorf("unknown command: %s", name)
+	}
+	return command(&Invocation{
+		Emulator: inv.Emulator,
+		Executor: inv.Executor,
+		Channel:  inv.Channel,
+		Command:  name,
+		Text:     text,
+		depth:    inv.depth,
+	})
+}
+
+// splitCommand splits the command name from the rest of the line
+func splitCommand(line string) (name, text string) {
+	line = strings.TrimSpace(line)
+	i := strings.IndexAny(line, " \t\n")
+	if i == -1 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
@@ -0,0 +1,192 @@
+// Package jsengine runs the functions of the js command with goja,
+// a javascript interpreter written in Go, with stand-ins for the supibot globals.
+package jsengine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/notnotquinn/supilang/emulator"
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+type Engine struct {
+	// Loads the code of a gist for importGist,
+	// by default it is read from the default gist cache of the compiler
+	LoadGist func(id string) (string, error)
+	// Maximum time a function can run for
+	Timeout time.Duration
+}
+
+// New returns an engine with a timeout of 5 seconds, that loads gists from the cache
+func New() *Engine {
+	return &Engine{
+		LoadGist: func(id string) (string, error) {
+			return sbl.CachedGist(sbl.Options{}, id)
+		},
+		Timeout: 5 * time.Second,
+	}
+}
+
+// Run the function in a new runtime, the result is the value of the last statement
+func (e *Engine) Run(ctx *emulator.JSContext) (string, error) {
+	vm := goja.New()
+	if err := setGlobals(vm, ctx); err != nil {
+		return "", err
+	}
+	if e.Timeout > 0 {
+		timer := time.AfterFunc(e.Timeout, func() {
+			vm.Interrupt("the function took too long to run")
+		})
+		defer timer.Stop()
+	}
+
+	if ctx.ImportGist != "" {
+		code, err := e.LoadGist(ctx.ImportGist)
+		if err != nil {
+			return "", fmt.Errorf("importGist: %w", err)
+		}
+		if _, err := vm.RunScript("gist-"+ctx.ImportGist+".js", code); err != nil {
+			return "", jsError(ctx, "importGist", err)
+		}
+	}
+	result, err := vm.RunScript("function.js", ctx.Function)
+	if err != nil {
+		return "", jsError(ctx, "js", err)
+	}
+	return resultText(vm, result)
+}
+
+// jsError is the error for a failed function, like supibot,
+// the details are only included with errorInfo:true
+func jsError(ctx *emulator.JSContext, what string, err error) error {
+	if !ctx.ErrorInfo {
+		return errors.New(what + ": your code failed, use errorInfo:true to see why")
+	}
+	var exception *goja.Exception
+	if errors.As(err, &exception) {
+		return errors.New(what + ": " + exception.Value().String())
+	}
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		return errors.New(what + ": " + fmt.Sprint(interrupted.Value()))
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+// resultText converts the result of the function to the output of the command
+func resultText(vm *goja.Runtime, result goja.Value) (string, error) {
+	if result == nil || goja.IsUndefined(result) {
+		return "", nil
+	}
+	if _, ok := result.Export().(string); ok {
+		return result.String(), nil
+	}
+	if obj, ok := result.(*goja.Object); ok && obj.ClassName() != "Function" {
+		bytes, err := json.Marshal(obj.Export())
+		if err != nil {
+			return "", fmt.Errorf("js: result: %w", err)
+		}
+		return string(bytes), nil
+	}
+	return result.String(), nil
+}
+
+// setGlobals sets args, executor, channel, customData and utils
+func setGlobals(vm *goja.Runtime, ctx *emulator.JSContext) error {
+	args := ctx.Args
+	if args == nil {
+		args = []string{}
+	}
+	argsArray := make([]interface{}, len(args))
+	for i, arg := range args {
+		argsArray[i] = arg
+	}
+	var channel interface{}
+	if ctx.Channel != "" {
+		channel = ctx.Channel
+	}
+
+	customData := vm.NewObject()
+	err := customData.Set("get", func(key string) goja.Value {
+		value, ok := ctx.CustomData[key]
+		if !ok {
+			return goja.Undefined()
+		}
+		return vm.ToValue(value)
+	})
+	if err != nil {
+		return err
+	}
+	err = customData.Set("set", func(key string, value goja.Value) {
+		if value == nil || goja.IsUndefined(value) {
+			delete(ctx.CustomData, key)
+			return
+		}
+		ctx.CustomData[key] = value.Export()
+	})
+	if err != nil {
+		return err
+	}
+
+	globals := map[string]interface{}{
+		"args":       vm.NewArray(argsArray...),
+		"executor":   ctx.Executor,
+		"channel":    channel,
+		"customData": customData,
+		"utils":      newUtils(vm),
+	}
+	for name, value := range globals {
+		if err := vm.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a few of the helpers supibot provides in utils
+func newUtils(vm *goja.Runtime) *goja.Object {
+	utils := vm.NewObject()
+	helpers := map[string]interface{}{
+		// random integer from min to max, including both
+		"random": func(min, max int64) int64 {
+			if max < min {
+				min, max = max, min
+			}
+			return min + rand.Int63n(max-min+1)
+		},
+		"randArray": func(array []interface{}) interface{} {
+			if len(array) == 0 {
+				return nil
+			}
+			return array[rand.Intn(len(array))]
+		},
+		"capitalize": func(s string) string {
+			if s == "" {
+				return s
+			}
+			return strings.ToUpper(s[:1]) + s[1:]
+		},
+		// pad a number with zeros to the length
+		"zf": func(number int64, length int) string {
+			return fmt.Sprintf("%0*d", length, number)
+		},
+		// cut a string to the length, ending with "…" if it was cut
+		"wrapString": func(s string, length int) string {
+			runes := []rune(s)
+			if length < 1 || len(runes) <= length {
+				return s
+			}
+			return string(runes[:length-1]) + "…"
+		},
+	}
+	for name, helper := range helpers {
+		utils.Set(name, helper)
+	}
+	return utils
+}
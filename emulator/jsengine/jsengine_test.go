@@ -0,0 +1,75 @@
+package jsengine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/notnotquinn/supilang/emulator"
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+func TestLocalKeys(t *testing.T) {
+	src := "alias xx prefixed \"xx-\"\n\tjs ```\n\t\tsetLocal(\"count\", (getLocal(\"count\") ?? 0) + 1)\n\t\treturn getLocalPrefix() + getLocal(\"count\")\n\t```\nend\n"
+	ast, err := sbl.Parse("test.sbl", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := sbl.Compile(ast, sbl.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := emulator.New()
+	e.JS = New()
+	if _, err := e.Run("tester", "", result.Aliases[0].Code); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"xx-1", "xx-2"} {
+		output, err := e.Run("tester", "", "$$xx")
+		if err != nil || output != want {
+			t.Errorf("got %q, %v, want %q", output, err, want)
+		}
+	}
+	if count := e.User("tester").CustomData["xx-count"]; count != int64(2) {
+		t.Errorf("customData has %#v for xx-count, want the key with the prefix", count)
+	}
+}
+
+func TestImportGist(t *testing.T) {
+	engine := New()
+	engine.LoadGist = func(id string) (string, error) {
+		if id != "abc" {
+			return "", errors.New("not cached")
+		}
+		return "var greeting = 'hello'", nil
+	}
+	output, err := engine.Run(&emulator.JSContext{Function: "greeting + ' ' + args[0]", Args: []string{"world"}, ImportGist: "abc"})
+	if err != nil || output != "hello world" {
+		t.Errorf("got %q, %v, want the gist to run before the function", output, err)
+	}
+	_, err = engine.Run(&emulator.JSContext{Function: "1", ImportGist: "def"})
+	if err == nil || err.Error() != "importGist: not cached" {
+		t.Errorf("got %v, want the error from loading the gist", err)
+	}
+}
+
+func TestErrorInfo(t *testing.T) {
+	tests := []struct {
+		ctx  *emulator.JSContext
+		want string
+	}{
+		{&emulator.JSContext{Function: "throw new Error('oops')"}, "js: your code failed, use errorInfo:true to see why"},
+		{&emulator.JSContext{Function: "throw new Error('oops')", ErrorInfo: true}, "js: Error: oops"},
+		{&emulator.JSContext{Function: "1", ImportGist: "abc", ErrorInfo: true}, "importGist: TypeError"},
+	}
+	engine := New()
+	engine.LoadGist = func(id string) (string, error) {
+		return "null.x", nil
+	}
+	for _, test := range tests {
+		_, err := engine.Run(test.ctx)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%q: got %v, want %q", test.ctx.Function, err, test.want)
+		}
+	}
+}
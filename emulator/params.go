@@ -0,0 +1,153 @@
+package emulator
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// parseParams finds the parameters with one of the names in text. A parameter
+// is a word like "name:value", or "name:\"quoted value\"" where \" is an escaped quote.
+// The other words are returned as arguments.
+func parseParams(text string, names []string) (map[string]string, []string, error) {
+	params := make(map[string]string)
+	args := []string{}
+	i := 0
+	for i < len(text) {
+		if isSpace(text[i]) {
+			i++
+			continue
+		}
+		name := paramAt(text[i:], names)
+		if name == "" {
+			end := wordEnd(text, i)
+			args = append(args, text[i:end])
+			i = end
+			continue
+		}
+		i += len(name) + 1
+		if i < len(text) && text[i] == '"' {
+			value, end, err := quotedValue(text, i)
+			if err != nil {
+				return nil, nil, err
+			}
+			params[name] = value
+			i = end
+		} else {
+			end := wordEnd(text, i)
+			params[name] = text[i:end]
+			i = end
+		}
+	}
+	return params, args, nil
+}
+
+// paramAt returns the name of the parameter at the start of text, if there is one
+func paramAt(text string, names []string) string {
+	for _, name := range names {
+		if strings.HasPrefix(text, name+":") {
+			return name
+		}
+	}
+	return ""
+}
+
+// quotedValue unescapes the quoted string starting at text[start],
+// returning it and the index after the closing quote
+func quotedValue(text string, start int) (string, int, error) {
+	var value strings.Builder
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			// like supibot, other backslashes are kept as they are
+			if i+1 < len(text) && text[i+1] == '"' {
+				i++
+			}
+			value.WriteByte(text[i])
+		case '"':
+			return value.String(), i + 1, nil
+		default:
+			value.WriteByte(text[i])
+		}
+	}
+	return "", 0, errors.New("unclosed quote in parameter")
+}
+
+func wordEnd(text string, start int) int {
+	i := start
+	for i < len(text) && !isSpace(text[i]) {
+		i++
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return unicode.IsSpace(rune(c))
+}
+
+// eg. "${0}", "${1+}", "${0-2}", "${-1}", "${1..3}", or "${executor}", like the ArgLiteral token of SBL
+var argLiteral = regexp.MustCompile(`\$\{(\d+\+?|-?\d+|-?\d+\.\.(?:-?\d+)?|\d+-\d+|executor|channel)\}`)
+
+// expandArgs replaces the argument literals in an alias definition.
+// If there are none, the arguments are added to the end of the definition.
+// ${a-b} includes both a and b, ${a..b} is like args.slice(a, b) in javascript,
+// where negative indexes count from the end, and b can be left out.
+// Arguments that do not exist are empty.
+func expandArgs(definition string, args []string, executor, channel string) string {
+	if !argLiteral.MatchString(definition) {
+		if len(args) == 0 {
+			return definition
+		}
+		return definition + " " + strings.Join(args, " ")
+	}
+	// index returns the index of an argument, counting from the end if it is negative
+	index := func(s string) int {
+		i, _ := strconv.Atoi(s)
+		if i < 0 {
+			i += len(args)
+		}
+		return i
+	}
+	// slice returns the arguments from from to to, including both
+	slice := func(from, to int) string {
+		if from < 0 {
+			from = 0
+		}
+		if from >= len(args) || to < from {
+			return ""
+		}
+		if to >= len(args) {
+			to = len(args) - 1
+		}
+		return strings.Join(args[from:to+1], " ")
+	}
+	return argLiteral.ReplaceAllStringFunc(definition, func(literal string) string {
+		inner := argLiteral.FindStringSubmatch(literal)[1]
+		switch {
+		case inner == "executor":
+			return executor
+		case inner == "channel":
+			return channel
+		case strings.HasSuffix(inner, "+"):
+			return slice(index(strings.TrimSuffix(inner, "+")), len(args)-1)
+		case strings.Contains(inner, ".."):
+			parts := strings.SplitN(inner, "..", 2)
+			to := len(args)
+			if parts[1] != "" {
+				to = index(parts[1])
+			}
+			return slice(index(parts[0]), to-1)
+		case strings.Index(inner, "-") > 0:
+			parts := strings.SplitN(inner, "-", 2)
+			return slice(index(parts[0]), index(parts[1]))
+		default:
+			i := index(inner)
+			if i < 0 {
+				return ""
+			}
+			return slice(i, i)
+		}
+	})
+}
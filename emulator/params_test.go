@@ -0,0 +1,36 @@
+package emulator
+
+import "testing"
+
+func TestExpandArgs(t *testing.T) {
+	args := []string{"a", "b", "c", "d", "e"}
+	tests := []struct {
+		definition string
+		args       []string
+		want       string
+	}{
+		{"abb say", args, "abb say a b c d e"},
+		{"abb say", nil, "abb say"},
+		{"abb say ${0}", args, "abb say a"},
+		{"abb say ${5}", args, "abb say "},
+		{"abb say ${2+}", args, "abb say c d e"},
+		{"abb say ${0+}", nil, "abb say "},
+		{"abb say ${1-3}", args, "abb say b c d"},
+		{"abb say ${3-9}", args, "abb say d e"},
+		{"abb say ${-1}", []string{"a", "b", "c"}, "abb say c"},
+		{"abb say ${-3}", []string{"a", "b", "c"}, "abb say a"},
+		{"abb say ${-4}", []string{"a", "b", "c"}, "abb say "},
+		{"abb say ${2..4}", args, "abb say c d"},
+		{"abb say ${2..}", args, "abb say c d e"},
+		{"abb say ${-4..-2}", args, "abb say b c"},
+		{"abb say ${-9..2}", args, "abb say a b"},
+		{"abb say ${3..1}", args, "abb say "},
+		{"abb say ${executor} ${channel} ${1}", args, "abb say tester forsen b"},
+	}
+	for _, test := range tests {
+		got := expandArgs(test.definition, test.args, "tester", "forsen")
+		if got != test.want {
+			t.Errorf("expandArgs(%q, %q) = %q, want %q", test.definition, test.args, got, test.want)
+		}
+	}
+}
@@ -5,7 +5,13 @@ go 1.17
 require (
 	github.com/alecthomas/participle/v2 v2.0.0-alpha7
 	github.com/alecthomas/repr v0.0.0-20181024024818-d37bc2a10ba1
+	github.com/dop251/goja v0.0.0-20220815083517-0c74f9139fd6
 	github.com/evanw/esbuild v0.14.18
 )
 
-require golang.org/x/sys v0.0.0-20210908233432-aa78b53d3365 // indirect
+require (
+	github.com/dlclark/regexp2 v1.7.0 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	golang.org/x/sys v0.0.0-20210908233432-aa78b53d3365 // indirect
+	golang.org/x/text v0.3.7 // indirect
+)
@@ -0,0 +1,140 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A JSON-RPC 2.0 request or notification (without an id)
+type request struct {
+	ID     *json.RawMessage `json:"id,omitempty"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// conn reads and writes messages with a Content-Length header, like the base protocol of LSP
+type conn struct {
+	in *bufio.Reader
+	// guards out, so messages are not interleaved
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newConn(in io.Reader, out io.Writer) *conn {
+	return &conn{in: bufio.NewReader(in), out: out}
+}
+
+// read the next message, the error is io.EOF when there are no more messages
+func (c *conn) read() (*request, error) {
+	length := -1
+	for {
+		line, err := c.in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length == -1 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header: %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %w", err)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.in, body); err != nil {
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+	req := &request{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, &responseError{codeParseError, err.Error()}
+	}
+	return req, nil
+}
+
+func (c *conn) write(message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.out.Write(body)
+	return err
+}
+
+func (c *conn) reply(id *json.RawMessage, result interface{}, err error) error {
+	resp := &response{JSONRPC: "2.0", ID: id, Result: result}
+	if err != nil {
+		var rerr *responseError
+		if !errors.As(err, &rerr) {
+			rerr = &responseError{codeInvalidRequest, err.Error()}
+		}
+		resp.Result = nil
+		resp.Error = rerr
+	}
+	return c.write(resp)
+}
+
+func (c *conn) notify(method string, params interface{}) error {
+	return c.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// cut is strings.Cut, which needs a newer version of go
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,196 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// The parts of the Language Server Protocol the server uses
+// https://microsoft.github.io/language-server-protocol/specifications/specification-current/
+
+// Position in a document, Character is in UTF-16 code units
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type Diagnostic struct {
+	Range              Range                          `json:"range"`
+	Severity           int                            `json:"severity"`
+	Code               string                         `json:"code,omitempty"`
+	Source             string                         `json:"source"`
+	Message            string                         `json:"message"`
+	RelatedInformation []DiagnosticRelatedInformation `json:"relatedInformation,omitempty"`
+}
+
+type DiagnosticRelatedInformation struct {
+	Location Location `json:"location"`
+	Message  string   `json:"message"`
+}
+
+// Diagnostic severities
+const (
+	severityError       = 1
+	severityWarning     = 2
+	severityInformation = 3
+)
+
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+// Only full changes are used, so a change is the whole text of the document
+type DidChangeTextDocumentParams struct {
+	TextDocument   TextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Text         *string                `json:"text"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
+
+type CompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Completion item kinds
+const (
+	completionKeyword = 14
+	completionValue   = 12
+	completionModule  = 9
+)
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo   struct {
+		Name string `json:"name"`
+	} `json:"serverInfo"`
+}
+
+type ServerCapabilities struct {
+	TextDocumentSync   TextDocumentSyncOptions `json:"textDocumentSync"`
+	HoverProvider      bool                    `json:"hoverProvider"`
+	DefinitionProvider bool                    `json:"definitionProvider"`
+	CompletionProvider struct {
+		TriggerCharacters []string `json:"triggerCharacters"`
+	} `json:"completionProvider"`
+}
+
+type TextDocumentSyncOptions struct {
+	OpenClose bool `json:"openClose"`
+	// 1 is the full text of the document on every change
+	Change int `json:"change"`
+	Save   struct {
+		IncludeText bool `json:"includeText"`
+	} `json:"save"`
+}
+
+// pathFromURI returns the path of a file:// URI
+func pathFromURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func uriFromPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// offsetOf returns the byte offset of a position in text
+func offsetOf(text string, pos Position) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i == -1 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+	for units := 0; offset < len(text) && text[offset] != '\n' && units < pos.Character; {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		units += utf16Length(r)
+		offset += size
+	}
+	return offset
+}
+
+// positionOf returns the position of a byte offset in text
+func positionOf(text string, offset int) Position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	pos := Position{}
+	for _, r := range text[:offset] {
+		if r == '\n' {
+			pos.Line++
+			pos.Character = 0
+		} else {
+			pos.Character += utf16Length(r)
+		}
+	}
+	return pos
+}
+
+func utf16Length(r rune) int {
+	if r > 0xFFFF {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,407 @@
+// Package lsp is a language server for SBL files, using the Language Server Protocol
+// over JSON-RPC. It publishes the diagnostics of the compiler when a file is opened
+// or saved, shows the compiled code and length of actions on hover, goes to the
+// definition of aliases and blocks, and completes keywords, keys and cached gist ids.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+type Server struct {
+	conn *conn
+	// open documents, by URI
+	docs map[string]*document
+	// URIs of other files that diagnostics were published for, by the URI of the document
+	// they were reported for, so they can be cleared
+	related map[string]map[string]bool
+	// options documents are compiled with, for their gists
+	options sbl.Options
+}
+
+// An open document
+type document struct {
+	uri, path, text string
+	// the last text that could be parsed, used for completion while the text is invalid
+	ast *sbl.SBLFile
+	// the text was parsed and compiled since it changed
+	checked bool
+	// ast is the current text
+	current bool
+	// aliases compiled from the current text, nil if it has errors
+	result *sbl.Result
+}
+
+// Serve runs a language server that reads messages from in and writes to out
+// (usually stdin and stdout), until the client sends exit or closes in.
+// Documents are compiled with every alias, and the gist settings of opts.
+func Serve(in io.Reader, out io.Writer, opts sbl.Options) error {
+	opts.All = true
+	s := &Server{
+		conn:    newConn(in, out),
+		docs:    make(map[string]*document),
+		related: make(map[string]map[string]bool),
+		options: opts,
+	}
+	for {
+		req, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		var rerr *responseError
+		if errors.As(err, &rerr) {
+			if err := s.conn.reply(nil, nil, err); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(req)
+		if req.ID == nil {
+			// notifications have no response
+			continue
+		}
+		if err := s.conn.reply(req.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req *request) (interface{}, error) {
+	// decode the params into v
+	params := func(v interface{}) error {
+		if err := json.Unmarshal(req.Params, v); err != nil {
+			return &responseError{codeInvalidParams, err.Error()}
+		}
+		return nil
+	}
+	switch req.Method {
+	case "initialize":
+		result := &InitializeResult{}
+		result.ServerInfo.Name = "supilang"
+		result.Capabilities.TextDocumentSync.OpenClose = true
+		result.Capabilities.TextDocumentSync.Change = 1
+		result.Capabilities.HoverProvider = true
+		result.Capabilities.DefinitionProvider = true
+		result.Capabilities.CompletionProvider.TriggerCharacters = []string{`"`}
+		return result, nil
+	case "initialized", "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		p := &DidOpenTextDocumentParams{}
+		if err := params(p); err != nil {
+			return nil, err
+		}
+		doc := &document{uri: p.TextDocument.URI, path: pathFromURI(p.TextDocument.URI), text: p.TextDocument.Text}
+		s.docs[doc.uri] = doc
+		return nil, s.publish(doc, s.check(doc))
+	case "textDocument/didChange":
+		p := &DidChangeTextDocumentParams{}
+		if err := params(p); err != nil {
+			return nil, err
+		}
+		doc := s.docs[p.TextDocument.URI]
+		if doc == nil || len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		doc.text = p.ContentChanges[len(p.ContentChanges)-1].Text
+		doc.checked, doc.current, doc.result = false, false, nil
+		return nil, nil
+	case "textDocument/didSave":
+		p := &DidSaveTextDocumentParams{}
+		if err := params(p); err != nil {
+			return nil, err
+		}
+		doc := s.docs[p.TextDocument.URI]
+		if doc == nil {
+			return nil, nil
+		}
+		if p.Text != nil {
+			doc.text = *p.Text
+			doc.checked = false
+		}
+		return nil, s.publish(doc, s.check(doc))
+	case "textDocument/didClose":
+		p := &DidCloseTextDocumentParams{}
+		if err := params(p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return nil, nil
+	case "textDocument/hover":
+		p := &TextDocumentPositionParams{}
+		if err := params(p); err != nil {
+			return nil, err
+		}
+		return s.hover(p), nil
+	case "textDocument/definition":
+		p := &TextDocumentPositionParams{}
+		if err := params(p); err != nil {
+			return nil, err
+		}
+		return s.definition(p), nil
+	case "textDocument/completion":
+		p := &TextDocumentPositionParams{}
+		if err := params(p); err != nil {
+			return nil, err
+		}
+		return s.completion(p), nil
+	}
+	if strings.HasPrefix(req.Method, "$/") {
+		// optional notifications, like $/cancelRequest
+		return nil, nil
+	}
+	return nil, &responseError{codeMethodNotFound, "method not supported: " + req.Method}
+}
+
+// check parses and compiles every alias of the document, returning the diagnostics
+func (s *Server) check(doc *document) sbl.Diagnostics {
+	doc.checked = true
+	doc.current = false
+	doc.result = nil
+	ast, err := sbl.Parse(doc.path, []byte(doc.text))
+	if err != nil {
+		return sbl.Diagnostics{sbl.DiagnosticFromError(err)}
+	}
+	doc.ast, doc.current = ast, true
+	result, err := sbl.Compile(ast, s.options)
+	if err == nil {
+		doc.result = result
+	}
+	return result.Diagnostics
+}
+
+// publish the diagnostics of a document, diagnostics in imported files are published for those files
+func (s *Server) publish(doc *document, diagnostics sbl.Diagnostics) error {
+	byURI := map[string][]Diagnostic{doc.uri: {}}
+	for _, d := range diagnostics {
+		uri := doc.uri
+		if d.Range.Start.Filename != "" {
+			uri = uriFromPath(d.Range.Start.Filename)
+		}
+		byURI[uri] = append(byURI[uri], s.diagnostic(d))
+	}
+	// clear the files that no longer have diagnostics
+	for uri := range s.related[doc.uri] {
+		if _, ok := byURI[uri]; !ok {
+			byURI[uri] = []Diagnostic{}
+		}
+	}
+	s.related[doc.uri] = make(map[string]bool)
+	for uri, diagnostics := range byURI {
+		if uri != doc.uri && len(diagnostics) > 0 {
+			s.related[doc.uri][uri] = true
+		}
+		err := s.conn.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{uri, diagnostics})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) diagnostic(d sbl.Diagnostic) Diagnostic {
+	out := Diagnostic{
+		Range:   s.lspRange(d.Range),
+		Code:    d.Code,
+		Source:  "sbl",
+		Message: d.Message,
+	}
+	switch d.Severity {
+	case sbl.SeverityError:
+		out.Severity = severityError
+	case sbl.SeverityWarning:
+		out.Severity = severityWarning
+	default:
+		out.Severity = severityInformation
+	}
+	for _, note := range d.Notes {
+		if note.Range.IsZero() {
+			out.Message += "\n" + note.Message
+			continue
+		}
+		out.RelatedInformation = append(out.RelatedInformation, DiagnosticRelatedInformation{
+			Location: Location{uriFromPath(note.Range.Start.Filename), s.lspRange(note.Range)},
+			Message:  note.Message,
+		})
+	}
+	return out
+}
+
+// lspRange converts a range of the compiler, a zero range is the start of the file
+func (s *Server) lspRange(r sbl.Range) Range {
+	if r.IsZero() {
+		return Range{}
+	}
+	text := s.fileText(r.Start.Filename)
+	end := r.End
+	if end.Line == 0 {
+		end = r.Start
+	}
+	return Range{positionOf(text, r.Start.Offset), positionOf(text, end.Offset)}
+}
+
+// fileText returns the text of an open document, or reads the file
+func (s *Server) fileText(path string) string {
+	for _, doc := range s.docs {
+		if doc.path == path {
+			return doc.text
+		}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+func (s *Server) hover(p *TextDocumentPositionParams) *Hover {
+	doc := s.docs[p.TextDocument.URI]
+	if doc == nil {
+		return nil
+	}
+	if !doc.checked {
+		s.check(doc)
+	}
+	if doc.result == nil {
+		return nil
+	}
+	offset := offsetOf(doc.text, p.Position)
+	contains := func(r sbl.Range) bool {
+		return r.Start.Filename == doc.path && offset >= r.Start.Offset && offset < r.End.Offset
+	}
+
+	// the smallest action or js block under the cursor
+	var part *sbl.LengthPart
+	var alias sbl.CompiledAlias
+	for _, a := range doc.result.Aliases {
+		for i := range a.Parts {
+			candidate := &a.Parts[i]
+			if !contains(candidate.Range) {
+				continue
+			}
+			if part == nil || candidate.Range.End.Offset-candidate.Range.Start.Offset < part.Range.End.Offset-part.Range.Start.Offset {
+				part, alias = candidate, a
+			}
+		}
+	}
+	if part != nil {
+		kind := "action"
+		if part.Kind == "js" {
+			kind = "js block"
+		}
+		r := s.lspRange(part.Range)
+		return &Hover{
+			Contents: MarkupContent{"markdown", fmt.Sprintf(
+				"This %s adds **%d** characters to alias `%s` (%d in total)\n\n%s",
+				kind, part.Length, alias.Name, alias.Length, codeBlock(part.Code),
+			)},
+			Range: &r,
+		}
+	}
+
+	// otherwise the whole alias, which can be split into several aliases
+	if !doc.current {
+		return nil
+	}
+	for _, d := range doc.ast.Declarations {
+		if d.Alias == nil || !contains(sbl.Range{Start: d.Pos, End: d.EndPos}) {
+			continue
+		}
+		text := ""
+		for _, a := range doc.result.Aliases {
+			if a.Name == d.Alias.Name || strings.HasPrefix(a.Name, d.Alias.Name+"__part") {
+				text += fmt.Sprintf("Alias `%s` is **%d** characters long\n\n%s\n\n", a.Name, a.Length, codeBlock(a.Code))
+			}
+		}
+		if text == "" {
+			return nil
+		}
+		r := s.lspRange(sbl.Range{Start: d.Pos, End: d.EndPos})
+		return &Hover{Contents: MarkupContent{"markdown", strings.TrimSpace(text)}, Range: &r}
+	}
+	return nil
+}
+
+// codeBlock formats code as a markdown code block, with a fence longer than any backticks in it
+func codeBlock(code string) string {
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + "\n" + code + "\n" + fence
+}
+
+func (s *Server) definition(p *TextDocumentPositionParams) *Location {
+	doc := s.docs[p.TextDocument.URI]
+	if doc == nil {
+		return nil
+	}
+	if !doc.checked {
+		s.check(doc)
+	}
+	if !doc.current {
+		return nil
+	}
+	r, ok := doc.ast.Definition(offsetOf(doc.text, p.Position))
+	if !ok {
+		return nil
+	}
+	return &Location{uriFromPath(r.Start.Filename), s.lspRange(r)}
+}
+
+var keywords = []string{
+	"alias", "block", "entry", "import", "test", "end", "prefixed", "with",
+	"exec", "pipe", "js", "inject", "say", "call", "get", "set", "local", "temp",
+	"compiled", "use", "if", "else", "dispatch", "case", "empty", "default", "options",
+	"run", "as", "in", "data", "stub", "expect", "output", "unset", "error",
+}
+
+// the cursor is in the gist id of "import" or "inject", eg. `js import "ab`
+var gistIDPrefix = regexp.MustCompile(`(?:import|inject)\s+(?:"[^"]*"\s*,\s*)*"[0-9a-fA-F]*$`)
+
+// completion suggests gist ids and keys inside strings, and keywords elsewhere
+func (s *Server) completion(p *TextDocumentPositionParams) []CompletionItem {
+	doc := s.docs[p.TextDocument.URI]
+	if doc == nil {
+		return nil
+	}
+	offset := offsetOf(doc.text, p.Position)
+	line := doc.text[strings.LastIndex(doc.text[:offset], "\n")+1 : offset]
+	items := []CompletionItem{}
+
+	if strings.Count(line, `"`)%2 == 0 {
+		for _, keyword := range keywords {
+			items = append(items, CompletionItem{Label: keyword, Kind: completionKeyword})
+		}
+		return items
+	}
+	if gistIDPrefix.MatchString(line) {
+		ids, _ := sbl.CachedGists(s.options)
+		for _, id := range ids {
+			items = append(items, CompletionItem{Label: id, Kind: completionModule, Detail: "cached gist"})
+		}
+		return items
+	}
+	if !doc.checked {
+		s.check(doc)
+	}
+	if doc.ast != nil {
+		for _, key := range doc.ast.Keys() {
+			items = append(items, CompletionItem{Label: key, Kind: completionValue, Detail: "key"})
+		}
+	}
+	return items
+}
@@ -1,605 +1,705 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"regexp"
-	"sort"
-	"strconv"
+	"path/filepath"
 	"strings"
 
-	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/alecthomas/repr"
-	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/notnotquinn/supilang/emulator"
+	"github.com/notnotquinn/supilang/emulator/jsengine"
+	"github.com/notnotquinn/supilang/lsp"
+	"github.com/notnotquinn/supilang/sbl"
 )
 
-type AliasOptions struct {
-	// Name of the alias for this scope
-	Aliasname string
-	// Key prefix used for this scope
-	Keyprefix string
-	// Force AliasBody to output $pipe command for this scope
-	ForcePipeCommand bool
-	// Force all instances of $js to use errorInfo:true for this scope
-	JSForceErrorInfo bool
-	// Disallow argument literals (${0+}, etc) for this scope
-	DisallowArgLiteral bool
-	// Minify javascript for this scope (will still be preprocessed, but not minified)
-	MinifyJS bool
-	// Do not remove temporary keys
-	KeepTempkeys bool
+// Exit codes
+const (
+	exitOK = 0
+	// the file has errors, a test failed, or a file is not formatted
+	exitFailed = 1
+	// the command line is invalid
+	exitUsage = 2
+	// a file could not be read or written
+	exitIO = 3
+)
+
+type command struct {
+	name string
+	// arguments of the command, for the usage
+	args        string
+	description string
+	run         func(args []string) int
 }
 
-func (a AliasOptions) Copy() *AliasOptions {
-	return &a
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"compile", "[flags] [file]", "compile a file to $alias addedit commands, one per line", compileCommand},
+		{"check", "[flags] [file]", "compile a file, only reporting errors and warnings", checkCommand},
+		{"ast", "[flags] [file]", "print the syntax tree of a file", astCommand},
+		{"fmt", "[flags] [files]", "format files in the canonical style", fmtCommand},
+		{"run", "[flags] file [args]", "run the compiled alias in an emulator, with the arguments after the file", runCommand},
+		{"test", "[flags] [file]", "run the tests in a file", testCommand},
+		{"decompile", "[flags] [file]", "convert $alias addedit commands to SBL", decompileCommand},
+		{"update", "[gist ids]", "fetch the latest revision of gists, and update " + sbl.LockFileName + " (every gist in it by default)", updateCommand},
+		{"cache", "list|prune|prefetch [flags] [args]", "list the cached gists, remove the ones not in " + sbl.LockFileName + ", or fetch gists (by id, or the ones used by .sbl files)", cacheCommand},
+		{"watch", "[flags] [dir]", "compile the files in a directory when they change", watchCommand},
+		{"lsp", "", "run a language server over stdin and stdout", lspCommand},
+		{"types", "[flags]", "print a TypeScript declaration file for the globals of js blocks", typesCommand},
+	}
 }
 
-func (a *Alias) Getoptions() *AliasOptions {
-	keyprefix := ""
-	if a.Keyprefix != nil {
-		keyprefix = *a.Keyprefix
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	name := os.Args[1]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
 	}
-	return &AliasOptions{
-		Aliasname:          a.Name,
-		Keyprefix:          keyprefix,
-		ForcePipeCommand:   false,
-		JSForceErrorInfo:   true,
-		DisallowArgLiteral: false,
-		MinifyJS:           true,
-		KeepTempkeys:       true,
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+	// "supilang file.sbl" is short for "supilang compile file.sbl",
+	// other words are more likely to be a mistyped command than a file
+	if _, err := os.Stat(name); err == nil || strings.HasPrefix(name, "-") || strings.HasSuffix(name, ".sbl") {
+		os.Exit(compileCommand(os.Args[1:]))
 	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(exitUsage)
 }
 
-type Commands struct {
-	aliasCommands []string
-	// tempKeys is an array of keys that will be unset after the alias has finished
-	tempKeys []string
+func usage() {
+	out := os.Stderr
+	fmt.Fprintf(out, "Usage: %s <command> [flags] [arguments]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(out, "\nFiles are read from stdin if the file is - or missing.\n")
+	fmt.Fprintf(out, "Run \"%s <command> -h\" for the flags of a command.\n", os.Args[0])
 }
 
-// appends c2 to the end of c1
-func (c1 *Commands) append(c2 *Commands) {
-	c1.aliasCommands = append(c1.aliasCommands, c2.aliasCommands...)
-	c1.tempKeys = append(c1.tempKeys, c2.tempKeys...)
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		for _, c := range commands {
+			if c.name == name {
+				fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n\n%s.\n\n", os.Args[0], c.name, c.args, strings.ToUpper(c.description[:1])+c.description[1:])
+			}
+		}
+		flags.PrintDefaults()
+	}
+	return flags
 }
 
-// Add a single command to the commands
-func (c *Commands) add(commandString string) {
-	c.aliasCommands = append(c.aliasCommands, commandString)
+// Flags of the commands that compile aliases
+type compileFlags struct {
+	all, lengthError, split bool
+	entry, lock             string
+	maxLength               int
 }
 
-type CompiledAliasBody struct {
-	// alias txt
-	bodyText string
-	// keys to be unset at the end of alias execution (that havent already been unset)
-	tempKeys []string
+func addCompileFlags(flags *flag.FlagSet) *compileFlags {
+	c := &compileFlags{}
+	flags.BoolVar(&c.all, "all", false, "compile every alias in the file, not just the entrypoint")
+	flags.StringVar(&c.entry, "entry", "", "compile this alias, instead of the entrypoint of the file")
+	flags.IntVar(&c.maxLength, "max-length", 0, "warn when a compiled alias is longer than this, 0 means no limit")
+	flags.BoolVar(&c.lengthError, "length-error", false, "fail instead of warning when an alias is longer than -max-length")
+	flags.BoolVar(&c.split, "split", false, "split aliases longer than -max-length into several aliases")
+	flags.StringVar(&c.lock, "lock", "", "path of the lock file for gists, by default "+sbl.LockFileName+" next to the file")
+	return c
 }
 
-// Compile the alias
-func (a *Alias) Compile() (string, error) {
-	out, err := a.Body.Compile(a.Getoptions())
-	if err != nil {
-		return "", fmt.Errorf("Alias: %w", err)
-	}
-	if len(out.tempKeys) > 0 {
-		return "", fmt.Errorf("uncleared tempKeys: %v", out.tempKeys)
+func (c *compileFlags) options() sbl.Options {
+	return sbl.Options{
+		Entry:       c.entry,
+		All:         c.all,
+		MaxLength:   c.maxLength,
+		LengthError: c.lengthError,
+		Split:       c.split,
+		LockFile:    c.lock,
 	}
-	return "$alias addedit " + a.Name + " " + out.bodyText, nil
 }
 
-// eg. "|123|" or "|"
-var pipechar = regexp.MustCompile(`\|(\d+)\||\|`)
+// Flags of the gist cache, with the fetcher set up from the environment
+type gistFlags struct {
+	fetcher  *sbl.GitHubFetcher
+	cacheDir string
+	offline  bool
+}
 
-func (ab *AliasBody) Compile(a *AliasOptions) (*CompiledAliasBody, error) {
-	// Commands are strings to be piped together
-	commands := []string{}
-	// keys to be removed after the alias finishes (completely)
-	tempKeys := []string{}
-	if len(ab.Actions) == 0 {
-		return nil, errors.New("an alias must have at least one action")
+// addCacheFlag registers -cache-dir, which sets the directory gists are cached in
+func addCacheFlag(flags *flag.FlagSet) *gistFlags {
+	g := &gistFlags{fetcher: &sbl.GitHubFetcher{API: "https://api.github.com", Token: os.Getenv("GITHUB_TOKEN")}}
+	if api := os.Getenv("SUPILANG_GIST_API"); api != "" {
+		g.fetcher.API = api
 	}
-
-	// compile actions, adding null command between them
-	for i, aa := range ab.Actions {
-		cmds, err := aa.Compile(a)
-		if err != nil {
-			return nil, fmt.Errorf("AliasBody: %w", err)
-		}
-		commands = append(commands, cmds.aliasCommands...)
-		tempKeys = append(tempKeys, cmds.tempKeys...)
-		if i+1 != len(ab.Actions) && len(cmds.aliasCommands) > 0 {
-			commands = append(commands, "abb say", "null")
-		}
+	dir := os.Getenv("SUPILANG_CACHE_DIR")
+	if dir == "" {
+		dir = sbl.DefaultCacheDir()
 	}
+	flags.StringVar(&g.cacheDir, "cache-dir", dir, "directory gists are cached in (also set with $SUPILANG_CACHE_DIR)")
+	return g
+}
 
-	// TODO: Nothing is stopping "recursed" alias bodies from resolving these
-	// they should only be "removed" by the root alias body
-	if a.KeepTempkeys {
-		tempKeys = nil
+// addLockFlag registers -lock, which sets the lock file used by the commands that manage gists
+func addLockFlag(flags *flag.FlagSet) *string {
+	return flags.String("lock", "", "path of the lock file for gists, by default "+sbl.LockFileName+" (next to each .sbl file for cache prefetch)")
+}
+
+// addGistFlags registers -cache-dir and -offline, for the commands that compile aliases
+func addGistFlags(flags *flag.FlagSet) *gistFlags {
+	g := addCacheFlag(flags)
+	flags.BoolVar(&g.offline, "offline", false, "fail when a gist is not cached, instead of fetching it")
+	return g
+}
+
+// options returns opts with the gist settings of the flags
+func (g *gistFlags) options(opts sbl.Options) sbl.Options {
+	opts.Fetcher = g.fetcher
+	opts.CacheDir = g.cacheDir
+	opts.Offline = g.offline
+	return opts
+}
+
+// newEmulator returns an emulator that runs js with goja, with gists from the cache
+func (g *gistFlags) newEmulator() *emulator.Emulator {
+	e := emulator.New()
+	js := jsengine.New()
+	js.LoadGist = func(id string) (string, error) {
+		return sbl.CachedGist(g.options(sbl.Options{}), id)
 	}
-	if len(tempKeys) > 0 {
-		deleteKeysJS := "let k = ["
-		for i, key := range tempKeys {
-			escapedKey := strings.ReplaceAll(key, `"`, `\\"`)
-			deleteKeysJS += `\"` + escapedKey + `\"`
-			if i+1 != len(tempKeys) {
-				deleteKeysJS += ","
+	e.JS = js
+	return e
+}
+
+// reporter writes diagnostics as text to stderr, or as JSON to stdout
+type reporter struct {
+	json bool
+	// only errors are reported
+	quiet bool
+}
+
+func addReportFlags(flags *flag.FlagSet) *reporter {
+	r := &reporter{}
+	flags.BoolVar(&r.json, "json", false, "write diagnostics to stdout as JSON")
+	flags.BoolVar(&r.quiet, "quiet", false, "only report errors, not warnings or other messages")
+	return r
+}
+
+func (r *reporter) report(diagnostics sbl.Diagnostics) {
+	if r.quiet {
+		errors := sbl.Diagnostics{}
+		for _, d := range diagnostics {
+			if d.Severity == sbl.SeverityError {
+				errors = append(errors, d)
 			}
 		}
-		deleteKeysJS += `];for(let i=0;i<k.length;i++)customData.set(k[i],undefined);`
-		// args.join(' ') must be last   // TODO: Some way to passthrough text without removing params
-		deleteKeysJS += "args.join(' ');"
-		errInfo := ""
-		if a.JSForceErrorInfo {
-			errInfo = "errorInfo:true "
-		}
-		deleteKeysCommand := `js ` + errInfo + `function:"` + deleteKeysJS + `"`
-		commands = append(commands, deleteKeysCommand)
-		tempKeys = nil
+		diagnostics = errors
 	}
-
-	if len(commands) == 0 {
-		return nil, errors.New("an alias must be equivelent to at least one command (your alias does nothing!)")
+	var err error
+	if r.json {
+		err = diagnostics.WriteJSON(os.Stdout)
+	} else {
+		err = diagnostics.WriteText(os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
+}
 
-	// used in "get compiled", must output a string ready to be input to $pipe no matter what
-	if a.ForcePipeCommand && len(commands) == 1 {
-		commands = append([]string{"null"}, commands...)
-	} else if len(commands) == 1 {
-		return &CompiledAliasBody{commands[0], tempKeys}, nil
+// readInput reads the file, or stdin if it is - or empty
+func readInput(filename string) (string, []byte, error) {
+	if filename == "" || filename == "-" {
+		src, err := io.ReadAll(os.Stdin)
+		return "<stdin>", src, err
 	}
+	src, err := os.ReadFile(filename)
+	return filename, src, err
+}
 
-	uniqueUsedPipeNums := make(map[int]bool)
-	// Find used pipe characters (meaning they exist anywhere in the commands)
-	// track the unique numbers they use
-	matches := pipechar.FindAllString(strings.Join(commands, " "), -1)
-	for _, v := range matches {
-		if v == "|" {
-			// -1 represents "|"
-			uniqueUsedPipeNums[-1] = true
-		} else {
-			numInChar, err := strconv.Atoi(v[1 : len(v)-1])
-			if err != nil {
-				return nil, fmt.Errorf("parse int in pipe char: %w", err)
-			}
-			uniqueUsedPipeNums[numInChar] = true
-		}
+// parse reads and parses a file (or stdin), and the files it imports.
+// If it fails, the error is reported and the exit code is returned.
+func parse(r *reporter, filename string) (*sbl.SBLFile, int) {
+	filename, src, err := readInput(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitIO
 	}
+	ast, err := sbl.Parse(filename, src)
+	if err != nil {
+		r.report(sbl.Diagnostics{sbl.DiagnosticFromError(err)})
+		return nil, exitFailed
+	}
+	return ast, exitOK
+}
 
-	// pipe char is "|x|" where x is the lowest unused number
-	pipeChar := "|"
-	if len(uniqueUsedPipeNums) > 0 {
-		num := 0
-		usedPipeNums := make([]int, 0, len(uniqueUsedPipeNums))
-		for k := range uniqueUsedPipeNums {
-			usedPipeNums = append(usedPipeNums, k)
-		}
+// writeOutput writes text to the file, or stdout if it is -
+func writeOutput(filename string, text string) int {
+	var err error
+	if filename == "-" {
+		_, err = io.WriteString(os.Stdout, text)
+	} else {
+		err = os.WriteFile(filename, []byte(text), 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	return exitOK
+}
 
-		// find lowest missing int
-		sort.Slice(usedPipeNums, func(i, j int) bool {
-			return usedPipeNums[i] < usedPipeNums[j]
-		})
-		for i, v := range usedPipeNums {
-			// list    [-1 0 1 2]
-			// indexes [ 0 1 2 3]
-			// v should equal the index - 1, if nothing is missing
-			if v != i-1 {
-				num = usedPipeNums[i-1] + 1
-				break
-			} else if i == len(usedPipeNums)-1 {
-				// nothing is missing, so increase by one
-				num = v + 1
-				break
+func compileCommand(args []string) int {
+	flags := newFlagSet("compile")
+	gists := addGistFlags(flags)
+	opts := addCompileFlags(flags)
+	r := addReportFlags(flags)
+	output := flags.String("o", "-", "write the aliases to this file, - is stdout (unless -json is used)")
+	lengths := flags.Bool("lengths", false, "print how many characters each action and js block adds to each alias")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	ast, code := parse(r, flags.Arg(0))
+	if ast == nil {
+		return code
+	}
+	options := gists.options(opts.options())
+	// only compiling records new gists in the lock file, checking doesn't change any files
+	options.WriteLock = true
+	result, err := sbl.Compile(ast, options)
+	r.report(result.Diagnostics)
+	if *lengths {
+		for _, alias := range result.Aliases {
+			fmt.Fprintf(os.Stderr, "%s: %d characters\n", alias.Name, alias.Length)
+			for _, part := range alias.Parts {
+				fmt.Fprintf(os.Stderr, "\t%s: %s: %d\n", part.Range, part.Kind, part.Length)
 			}
 		}
-		pipeChar = "|" + fmt.Sprint(num) + "|"
 	}
-	return &CompiledAliasBody{"pipe _char:" + pipeChar + " " + strings.Join(commands, pipeChar), tempKeys}, nil
+	if err != nil {
+		return exitFailed
+	}
+	if r.json && *output == "-" {
+		// stdout has the diagnostics
+		return exitOK
+	}
+	return writeOutput(*output, result.Code()+"\n")
+}
+
+func checkCommand(args []string) int {
+	flags := newFlagSet("check")
+	gists := addGistFlags(flags)
+	opts := addCompileFlags(flags)
+	r := addReportFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	ast, code := parse(r, flags.Arg(0))
+	if ast == nil {
+		return code
+	}
+	result, err := sbl.Compile(ast, gists.options(opts.options()))
+	r.report(result.Diagnostics)
+	if err != nil {
+		return exitFailed
+	}
+	return exitOK
 }
-func (aa *AliasAction) Compile(a *AliasOptions) (*Commands, error) {
-	out := &Commands{}
-	if aa.ExecuteAction != nil {
-		cmds, err := aa.ExecuteAction.Compile(a)
+
+func astCommand(args []string) int {
+	flags := newFlagSet("ast")
+	jsonOutput := flags.Bool("json", false, "write the syntax tree as JSON, instead of Go syntax")
+	output := flags.String("o", "-", "write the syntax tree to this file, - is stdout")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	r := &reporter{json: *jsonOutput}
+	ast, code := parse(r, flags.Arg(0))
+	if ast == nil {
+		return code
+	}
+	var text string
+	if *jsonOutput {
+		out, err := json.MarshalIndent(ast, "", "  ")
 		if err != nil {
-			return nil, fmt.Errorf("AliasAction: %w", err)
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailed
 		}
-		out.append(cmds)
-	} else if aa.GetCompiledAction != nil {
-		cmds, err := aa.GetCompiledAction.Compile(a)
+		text = string(out)
+	} else {
+		// only the declarations, repr would also print the unexported fields of the file
+		text = repr.String(ast.Declarations, repr.Indent("  "), repr.Hide(lexer.Position{}), repr.OmitEmpty(true))
+	}
+	return writeOutput(*output, text+"\n")
+}
+
+// fmtCommand prints the formatted files, or with -check lists the files that are not formatted,
+// and with -write rewrites them. Without files, stdin is formatted to stdout.
+func fmtCommand(args []string) int {
+	flags := newFlagSet("fmt")
+	check := flags.Bool("check", false, "list the files that are not formatted, and exit with status 1 if there are any")
+	write := flags.Bool("write", false, "write the formatted files, instead of printing them")
+	flags.Parse(args)
+	filenames := flags.Args()
+	if len(filenames) == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "fmt: -write needs files")
+			return exitUsage
+		}
+		filenames = []string{"-"}
+	}
+
+	r := &reporter{}
+	status := exitOK
+	for _, filename := range filenames {
+		filename, src, err := readInput(filename)
 		if err != nil {
-			return nil, fmt.Errorf("AliasAction: %w", err)
+			fmt.Fprintln(os.Stderr, err)
+			return exitIO
 		}
-		out.append(cmds)
-	}
-	// if aa.ContinueAction != nil {
-	// 	cmds, err := aa.ContinueAction.Compile(a)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("AliasAction: %w", err)
-	// 	}
-	// 	out = append(out, cmds...)
-	// }
-	return out, nil
-}
-func (ca *GetCompiledAction) Compile(a *AliasOptions) (commands *Commands, err error) {
-	commands = &Commands{}
-	if ca.CompilationRoot != nil {
-		aliasOpts := a.Copy()
-		aliasOpts.ForcePipeCommand = true
-		aliasOpts.DisallowArgLiteral = true
-		result, err := ca.CompilationRoot.Compile(aliasOpts)
+		out, err := sbl.Format(filename, src)
 		if err != nil {
-			return nil, err
+			r.report(sbl.Diagnostics{sbl.DiagnosticFromError(err)})
+			return exitFailed
+		}
+		changed := !bytes.Equal(src, out)
+		if *check && changed {
+			fmt.Println(filename)
+			status = exitFailed
+		}
+		if *write && changed {
+			if err := os.WriteFile(filename, out, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitIO
+			}
 		}
-		commands.tempKeys = append(commands.tempKeys, result.tempKeys...)
-		execString := result.bodyText
-		if err != nil {
-			return nil, fmt.Errorf("GetCompiledAction: %w", err)
+		if !*check && !*write {
+			os.Stdout.Write(out)
 		}
+	}
+	return status
+}
+
+func runCommand(args []string) int {
+	flags := newFlagSet("run")
+	gists := addGistFlags(flags)
+	entry := flags.String("entry", "", "run this alias, instead of the entrypoint of the file")
+	stubs := stubFlag{}
+	flags.Var(stubs, "stub", "make a command output some text, as name=output (can be repeated)")
+	r := addReportFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	ast, code := parse(r, flags.Arg(0))
+	if ast == nil {
+		return code
+	}
+	// the aliases the entry calls are defined in the emulator too
+	result, err := sbl.Compile(ast, gists.options(sbl.Options{Entry: *entry, Called: true}))
+	r.report(result.Diagnostics)
+	if err != nil {
+		return exitFailed
+	}
+	output, err := runAliases(gists.newEmulator(), result, stubs, flags.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	fmt.Println(output)
+	return exitOK
+}
 
-		// escape two sets of quotes, one for function param, one for javascript string literal
-		// and remove "pipe " from the string
-		escapedString := strings.Replace(execString[5:], `\`, `\\`, -1)
-		escapedString = strings.Replace(escapedString, `"`, `\"`, -1)
-		escapedString = strings.Replace(escapedString, `'`, `\'`, -1)
-		escapedString = strings.Replace(escapedString, "\n", "", -1)
-		// escape params
-		escapedString = strings.Replace(escapedString, `:`, `'+':`, -1)
-		// escape arg literals
-		// escapedString = strings.Replace(escapedString, `${`, `$'+'{`, -1)
-		errInfo := ""
-		if a.JSForceErrorInfo {
-			errInfo = "errorInfo:true "
+func testCommand(args []string) int {
+	flags := newFlagSet("test")
+	gists := addGistFlags(flags)
+	maxLength := flags.Int("max-length", 0, "warn when a compiled alias is longer than this, 0 means no limit")
+	split := flags.Bool("split", false, "split aliases longer than -max-length into several aliases")
+	r := addReportFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	ast, code := parse(r, flags.Arg(0))
+	if ast == nil {
+		return code
+	}
+	testReport, err := sbl.RunTests(ast, gists.options(sbl.Options{MaxLength: *maxLength, Split: *split}), gists.newEmulator)
+	if r.json {
+		if err := writeTestJSON(testReport); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitIO
 		}
-		if ca.ContinueAction != nil && ca.ContinueAction.StoreKey != nil {
-			key := *ca.ContinueAction.StoreKey
-			if ca.ContinueAction.StoreKeyLocal {
-				key = a.Keyprefix + key
-			}
-			if ca.ContinueAction.StoreKeyTemp {
-				commands.tempKeys = append(commands.tempKeys, key)
+	} else {
+		r.report(testReport.Diagnostics)
+		for _, t := range testReport.Tests {
+			if !t.Passed() {
+				fmt.Printf("FAIL %s\n", t.Name)
+				r.report(t.Failures)
+			} else if !r.quiet {
+				fmt.Printf("PASS %s\n", t.Name)
 			}
-
-			ca.ContinueAction.StoreKey = nil
-			ca.ContinueAction.StoreKeyLocal = false
-			ca.ContinueAction.StoreKeyTemp = false
-			escapedKey := strings.Replace(key, `"`, `\\"`, -1)
-			commands.add("js " + errInfo + "function:\" customData.set(\\\"" + escapedKey + "\\\",'" + escapedString + "') \"")
-		} else {
-			commands.add("js " + errInfo + "function:\" '" + escapedString + "' \"")
 		}
 	}
-	if ca.ContinueAction != nil {
-		cmds, err := ca.ContinueAction.Compile(a)
-		if err != nil {
-			return nil, fmt.Errorf("GetCompiledAction: %w", err)
-		}
-		commands.append(cmds)
+	if err != nil || !testReport.Passed() {
+		return exitFailed
 	}
-	return
+	return exitOK
 }
-func (ea *ExecuteAction) Compile(a *AliasOptions) (*Commands, error) {
-	commands := Commands{}
-	if ea.RetrieveAction != nil {
-		cmds, err := ea.RetrieveAction.Compile(a)
-		if err != nil {
-			return nil, fmt.Errorf("ExecuteAction: %w", err)
-		}
-		commands.append(cmds)
+
+// writeTestJSON writes the results of the tests to stdout
+func writeTestJSON(report *sbl.TestReport) error {
+	type testJSON struct {
+		Name     string          `json:"name"`
+		Range    sbl.Range       `json:"range"`
+		Passed   bool            `json:"passed"`
+		Failures sbl.Diagnostics `json:"failures"`
+	}
+	tests := []testJSON{}
+	for _, t := range report.Tests {
+		failures := t.Failures
+		if failures == nil {
+			failures = sbl.Diagnostics{}
+		}
+		tests = append(tests, testJSON{t.Name, t.Range, t.Passed(), failures})
+	}
+	diagnostics := report.Diagnostics
+	if diagnostics == nil {
+		diagnostics = sbl.Diagnostics{}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Diagnostics sbl.Diagnostics `json:"diagnostics"`
+		Tests       []testJSON      `json:"tests"`
+	}{diagnostics, tests})
+}
+
+func decompileCommand(args []string) int {
+	flags := newFlagSet("decompile")
+	name := flags.String("name", "decompiled", "name of the aliases that are not \"$alias addedit\" commands")
+	output := flags.String("o", "-", "write the SBL to this file, - is stdout")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
 	}
-	if ea.SimpleAction != nil {
-		cmds, err := ea.SimpleAction.Compile(a)
-		if err != nil {
-			return nil, fmt.Errorf("ExecuteAction: %w", err)
-		}
-		commands.append(cmds)
+	_, src, err := readInput(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
 	}
-	if ea.ContinueAction != nil {
-		cmds, err := ea.ContinueAction.Compile(a)
-		if err != nil {
-			return nil, fmt.Errorf("ExecuteAction: %w", err)
-		}
-		commands.append(cmds)
+	out, err := sbl.Decompile(string(src), *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
 	}
-	return &commands, nil
+	return writeOutput(*output, out)
 }
-func (ra *RetrieveAction) Compile(a *AliasOptions) (commands *Commands, err error) {
-	commands = &Commands{}
-	if ra.RetrieveKey != nil {
-		key := *ra.RetrieveKey
-		if ra.LocalRetrieveKey {
-			key = a.Keyprefix + *ra.RetrieveKey
-		}
 
-		escapedKey := strings.Replace(key, `"`, `\\"`, -1)
-		errInfo := ""
-		if a.JSForceErrorInfo {
-			errInfo = "errorInfo:true "
-		}
-		commands.add(`js ` + errInfo + `function:"customData.get(\"` + escapedKey + `\")"`)
-	} else if ra.RetrieveArgs != nil {
-		if a.DisallowArgLiteral {
-			// can be disabled because "get compiled" will mess with them, making them unreliable
-			return nil, participle.Errorf(ra.Pos, "arg literals are not allowed in this context")
+func updateCommand(args []string) int {
+	flags := newFlagSet("update")
+	gists := addCacheFlag(flags)
+	lock := addLockFlag(flags)
+	flags.Parse(args)
+	updates, warnings, err := sbl.UpdateGists(gists.options(sbl.Options{LockFile: *lock}), flags.Args())
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	for _, u := range updates {
+		if u.Old.Revision == "" {
+			fmt.Printf("%s: added revision %s\n", u.ID, u.New.Revision)
+		} else {
+			fmt.Printf("%s: %s -> %s\n", u.ID, u.Old.Revision, u.New.Revision)
 		}
-		commands.add(`abb say ` + *ra.RetrieveArgs)
 	}
-	return
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	return exitOK
 }
-func (ca *ContinuedAction) Compile(a *AliasOptions) (commands *Commands, err error) {
-	commands = &Commands{}
-	if ca.StoreKey != nil {
-		key := *ca.StoreKey
-		if ca.StoreKeyLocal {
-			key = a.Keyprefix + *ca.StoreKey
-		}
-		fmt.Printf("ca: %v\n", ca)
-		if ca.StoreKeyTemp {
-			fmt.Printf("key: %v\n", key)
-			commands.tempKeys = append(commands.tempKeys, key)
-		}
 
-		escapedKey := strings.Replace(key, `"`, `\\"`, -1)
-		errInfo := ""
-		if a.JSForceErrorInfo {
-			errInfo = "errorInfo:true "
-		}
-		commands.add(`js ` + errInfo + `function:"customData.set(\"` + escapedKey + `\", args.join(' '))"`)
-	} else if ca.NextAction != nil {
-		cmds, err := ca.NextAction.Compile(a)
+func cacheCommand(args []string) int {
+	if len(args) == 0 {
+		newFlagSet("cache").Usage()
+		return exitUsage
+	}
+	flags := newFlagSet("cache")
+	gists := addCacheFlag(flags)
+	lock := addLockFlag(flags)
+	switch args[0] {
+	case "list":
+		flags.Parse(args[1:])
+		ids, err := sbl.CachedGists(gists.options(sbl.Options{}))
 		if err != nil {
-			return nil, fmt.Errorf("ContinuedAction: %w", err)
+			fmt.Fprintln(os.Stderr, err)
+			return exitIO
+		}
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+	case "prune":
+		all := flags.Bool("all", false, "remove every cached gist, including the ones in "+sbl.LockFileName)
+		flags.Parse(args[1:])
+		removed, err := sbl.PruneCache(gists.options(sbl.Options{LockFile: *lock}), *all)
+		for _, id := range removed {
+			fmt.Printf("removed %s\n", id)
 		}
-		commands.append(cmds)
-	}
-	if ca.SecondContinue != nil {
-		cmds, err := ca.SecondContinue.Compile(a)
 		if err != nil {
-			return nil, fmt.Errorf("ContinuedAction: %w", err)
+			fmt.Fprintln(os.Stderr, err)
+			return exitIO
 		}
-		commands.append(cmds)
+	case "prefetch":
+		flags.Parse(args[1:])
+		return prefetch(gists.options(sbl.Options{LockFile: *lock}), flags.Args())
+	default:
+		flags.Usage()
+		return exitUsage
 	}
-	return
+	return exitOK
 }
-func (ea *ExecuteActionSimple) Compile(a *AliasOptions) (*Commands, error) {
-	out := &Commands{}
-	if ea.JSExec != nil {
-		cmds, err := ea.JSExec.Compile(a)
-		if err != nil {
-			return nil, fmt.Errorf("ExecuteActionSimple: %w", err)
-		}
-		out.append(cmds)
-	} else if ea.PipeCommandLiterals != nil {
-		out.aliasCommands = append(out.aliasCommands, ea.PipeCommandLiterals...)
-	} else if ea.CallAlias != nil {
-		cmds, err := ea.CallAlias.Compile(a)
+
+// prefetch caches gists by id, and the gists imported and injected by .sbl files,
+// recording them in the lock file of opts. Without one, the gists of a .sbl file
+// are recorded in the lock file next to it, like when it is compiled.
+func prefetch(opts sbl.Options, args []string) int {
+	type gist struct {
+		id   string
+		opts sbl.Options
+	}
+	gists := []gist{}
+	for _, arg := range args {
+		if !strings.HasSuffix(arg, ".sbl") {
+			gists = append(gists, gist{arg, opts})
+			continue
+		}
+		ast, err := sbl.ParseFile(arg)
 		if err != nil {
-			return nil, fmt.Errorf("ExecuteActionSimple: %w", err)
+			(&reporter{}).report(sbl.Diagnostics{sbl.DiagnosticFromError(err)})
+			return exitFailed
 		}
-		out.append(cmds)
-	} else if ea.UseSayLiteral {
-		// since say will just output its input, we can optimize it out
-		// as long as you dont append any text
-		if ea.SayLiteral != nil {
-			out.add("abb say " + *ea.SayLiteral)
+		fileOpts := opts
+		if fileOpts.LockFile == "" {
+			fileOpts.LockFile = filepath.Join(filepath.Dir(arg), sbl.LockFileName)
 		}
-	} else {
-		return nil, errors.New("invalid ExecuteActionSimple")
-	}
-	return out, nil
-}
-func (jsa *JSExecAction) Compile(a *AliasOptions) (commands *Commands, err error) {
-	commands = &Commands{}
-	// Calculate injected Javascript
-	unescapedJSCode := strings.Replace(jsa.ExecString.RawString, "\\`", "`", -1)
-
-	escapedKeyprefix := strings.Replace(a.Keyprefix, `"`, `\"`, -1)
-	escapedKeyprefix = strings.Replace(escapedKeyprefix, "\n", "\\n", -1)
-	// runtime functions to interact with local keys
-	injectedRuntime := `
-		// get the local value for the key
-		function getLocal(key) {
-			return customData.get("` + escapedKeyprefix + `"+key)
+		for _, id := range ast.Gists() {
+			gists = append(gists, gist{id, fileOpts})
 		}
-		// set the local value for the key
-		function setLocal(key, value) {
-			return customData.set("` + escapedKeyprefix + `"+key, value)
-		}
-		// get the local key prefix
-		function getLocalPrefix() {
-			return "` + escapedKeyprefix + `"
+	}
+	status := exitOK
+	for _, g := range gists {
+		warnings, err := sbl.PrefetchGist(g.opts, g.id)
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: gist %s: %s\n", g.id, w)
 		}
-`
-	// injected gists
-	injectedGistsContent := []string{}
-	for _, id := range jsa.InjectedGists {
-		content, err := getGistContent(id)
 		if err != nil {
-			return nil, participle.Errorf(jsa.Pos, "get gist content: %s", err.Error())
-		}
-		injectedGistsContent = append(injectedGistsContent, content)
-	}
-	injectedGistJS := strings.Join(injectedGistsContent, "\n\n")
-	// Final injected code
-	injectedCode := injectedGistJS + "\n\n" + injectedRuntime + "\n\n"
-	// Minify code so that it can fit on one line, because I'm not parsing that shit
-	res := esbuild.Transform(injectedCode+unescapedJSCode, esbuild.TransformOptions{
-		Loader:            esbuild.LoaderJS,
-		Drop:              esbuild.DropConsole, // console doesnt even exist in $js
-		IgnoreAnnotations: true,
-		// Tree shake to remove our runtime if it doesnt get used
-		TreeShaking: esbuild.TreeShakingTrue,
-		// always minify whitespace to trim newlines
-		MinifyWhitespace:  true,
-		MinifyIdentifiers: a.MinifyJS,
-		MinifySyntax:      a.MinifyJS,
-	})
-
-	if len(res.Errors) > 0 || len(res.Warnings) > 0 {
-		locationToString := func(l lexer.Position, l2 esbuild.Location) string {
-			// calculate the actual locaiton of l2 in our source file
-			// based on where the js token started
-			var loc lexer.Position
-			injectedLines := strings.Split(injectedCode, "\n")
-			// If its the first line of where the user wrote....
-			if l2.Line-len(injectedLines)+1 == 1 {
-				lenLastInjectedLine := len(injectedLines[len(injectedLines)-1])
-				loc.Column =
-					//  text within source file, before js starts
-					l.Column + len("```") +
-						//  text written after the backtics
-						(l2.Column - lenLastInjectedLine) +
-						//  offset for escaping the backtic character with backslash
-						strings.Count(l2.LineText[lenLastInjectedLine:], "`")
-			} else {
-				// add one for every backtic, because those are written as "\`"
-				loc.Column = l2.Column + 1 + strings.Count(l2.LineText, "`")
-			}
-			loc.Filename = l.Filename
-			loc.Line = l.Line + l2.Line - len(injectedLines)
-			return loc.String()
-		}
-		for _, m := range res.Warnings {
-			log.Printf("Minify JS (warning): %s: %s\n", locationToString(jsa.ExecString.Pos, *m.Location), m.Text)
-			for _, n := range m.Notes {
-				log.Printf("Minify JS (warning): %s: Note: %s\n", locationToString(jsa.ExecString.Pos, *n.Location), n.Text)
-			}
-		}
-		for _, m := range res.Errors {
-			log.Printf("Minify JS: %s: %s\n", locationToString(jsa.ExecString.Pos, *m.Location), m.Text)
-			for _, n := range m.Notes {
-				log.Printf("Minify JS: %s: Note: %s\n", locationToString(jsa.ExecString.Pos, *n.Location), n.Text)
-			}
-		}
-		if len(res.Errors) > 0 {
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "gist %s: %s\n", g.id, err)
+			status = exitFailed
 		}
 	}
+	return status
+}
 
-	// this string must not start or end with a double quote
-	// supibot trims them, thinking they are part of the parameter.
-	minifiedCode := `(()=>{` + string(res.Code) + `})();`
-
-	// Escape quote for funciton param
-	escapedMinifiedCode := strings.Replace(minifiedCode, `\`, `\\`, -1)
-	escapedMinifiedCode = strings.Replace(escapedMinifiedCode, `"`, `\"`, -1)
-	// remove newlines (just in case there is any, for some reason)
-	escapedMinifiedCode = strings.Replace(escapedMinifiedCode, "\n", "", -1)
-	errInfo := ""
-	if a.JSForceErrorInfo {
-		errInfo = "errorInfo:true "
-	}
-	importGist := ""
-	if jsa.ImportedGist != nil {
-		if match, err := regexp.MatchString("^[0-9a-fA-F]*$", *jsa.ImportedGist); !match || err != nil {
-			if err != nil {
-				return nil, err
-			}
-			return nil, participle.Errorf(jsa.Pos, "gist ids can only contain hexadecimal characters (0123456789abcdefABCDEF)")
-		}
-		if *jsa.ImportedGist == "" {
-			return nil, participle.Errorf(jsa.Pos, "a gist id cannot be the empty string")
-		}
-		importGist = "importGist:" + *jsa.ImportedGist + " "
-	}
-	commands = &Commands{}
-	commands.add("js " + errInfo + importGist + "function:\"" + escapedMinifiedCode + "\"")
-	return commands, nil
+func watchCommand(args []string) int {
+	flags := newFlagSet("watch")
+	gists := addGistFlags(flags)
+	opts := addCompileFlags(flags)
+	r := addReportFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	dir := "."
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+	if err := watch(dir, gists.options(opts.options()), r.report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
+	}
+	return exitOK
 }
-func (ca *CallAliasAction) Compile(a *AliasOptions) (commands *Commands, err error) {
-	commands = &Commands{}
-	if ca.User != nil {
-		commands.add(`alias try ` + *ca.User + ` ` + ca.AliasName)
-	} else {
-		commands.add(`$ ` + ca.AliasName)
-	}
-	return
-}
-
-var processToken = func(trimleft, quotesize int, unquote bool, types ...string) func(p *participle.Parser) error {
-	unquoteWithChar := func(s string) (string, error) {
-		quote := s[trimleft]
-		s = s[trimleft+quotesize : len(s)-quotesize]
-		out := ""
-		for s != "" {
-			if unquote {
-				value, _, tail, err := strconv.UnquoteChar(s, quote)
-				if err != nil {
-					return "", err
-				}
-				s = tail
-				out += string(value)
-			} else {
-				out += s
-				s = ""
-			}
-		}
-		return out, nil
-	}
-	if len(types) == 0 {
-		return nil
+
+func lspCommand(args []string) int {
+	flags := newFlagSet("lsp")
+	gists := addGistFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return exitUsage
 	}
-	return participle.Map(func(t lexer.Token) (lexer.Token, error) {
-		value, err := unquoteWithChar(t.Value)
-		if err != nil {
-			return t, participle.Errorf(t.Pos, "invalid quoted string %q: %s", t.Value, err.Error())
-		}
-		t.Value = value
-		return t, nil
-	}, types...)
-}
-
-func (ast *SBLFile) Compile() (string, error) {
-	var entry string
-	aliases := make(map[string]*Alias)
-	for _, d := range ast.Declarations {
-		if d.Entrypoint != nil && entry == "" {
-			entry = *d.Entrypoint
-		} else if d.Entrypoint != nil {
-			return "", participle.Errorf(d.Pos, "only one entrypoint can be specified per file")
-		} else if d.Alias != nil {
-			if aliases[d.Alias.Name] != nil {
-				return "", participle.Errorf(d.Pos, "duplicate alias definition: %s", d.Alias.Name)
-			}
-			aliases[d.Alias.Name] = d.Alias
-		} else {
-			return "", participle.Errorf(d.Pos, "invalid declaration")
-		}
+	// the language server talks to the editor over stdin and stdout
+	if err := lsp.Serve(os.Stdin, os.Stdout, gists.options(sbl.Options{})); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIO
 	}
-	if entry == "" && len(aliases) == 1 {
-		return ast.Declarations[0].Alias.Compile()
-	} else if aliases[entry] != nil {
-		return aliases[entry].Compile()
-	} else {
-		return "", fmt.Errorf("entrypoint can only be omitted if there is one alias")
+	return exitOK
+}
+
+func typesCommand(args []string) int {
+	flags := newFlagSet("types")
+	output := flags.String("o", "-", "write the declarations to this file, - is stdout")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return exitUsage
 	}
+	return writeOutput(*output, sbl.SandboxTypes)
 }
 
-func main() {
-	var filename string
-	if len(os.Args) > 1 {
-		filename = os.Args[1]
-	} else {
-		fmt.Printf("Usage: %s file\n", os.Args[0])
-		os.Exit(1)
+// Commands that output fixed text when running aliases, by name
+type stubFlag map[string]string
+
+func (s stubFlag) String() string {
+	return ""
+}
+
+func (s stubFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 1 {
+		return errors.New("expected name=output")
 	}
-	bytes, err := os.ReadFile(filename)
-	if err != nil {
-		log.Fatal("open: ", err)
+	s[value[:i]] = value[i+1:]
+	return nil
+}
+
+// runAliases defines the compiled aliases in an emulator, and runs the last one
+func runAliases(e *emulator.Emulator, result *sbl.Result, stubs stubFlag, args []string) (string, error) {
+	if len(result.Aliases) == 0 {
+		return "", errors.New("there is no alias to run")
 	}
-	fileAST := &SBLFile{}
-	err = parser.ParseBytes(filename, bytes, fileAST)
-	if err != nil {
-		log.Fatal(err)
+	for name, output := range stubs {
+		e.Register(name, emulator.Reply(output))
 	}
-	repr.Println(fileAST, repr.Indent("  "), repr.Hide(lexer.Position{}), repr.OmitEmpty(true))
-	code, err := fileAST.Compile()
-	if err != nil {
-		log.Fatal(err)
+	for _, alias := range result.Aliases {
+		if _, err := e.Run("sbl", "local", alias.Code); err != nil {
+			return "", err
+		}
 	}
-	fmt.Println(code)
-
-	os.WriteFile("out.alias", []byte(code), 0644)
-
+	last := result.Aliases[len(result.Aliases)-1]
+	return e.Run("sbl", "local", "$$"+last.Name+" "+strings.Join(args, " "))
 }
@@ -0,0 +1,41 @@
+package sbl_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+func TestUseBlockSplicesCommands(t *testing.T) {
+	src := `
+block greet
+	exec "abb say hi" -> set temp "greeting"
+	get "greeting" -> js ` + "```" + `return args.join(" ") + "!"` + "```" + `
+end
+
+alias xx
+	use greet
+	say "after"
+end
+
+test "the block runs in the alias"
+	run xx
+	expect output "after"
+	expect unset "greeting"
+end
+`
+	ast, err := sbl.Parse("test.sbl", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := sbl.Compile(ast, sbl.Options{Entry: "xx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := res.Code()
+	if strings.Count(code, "pipe") != 1 {
+		t.Errorf("the block was compiled to a nested pipe: %s", code)
+	}
+	runTests(t, src, sbl.Options{})
+}
@@ -0,0 +1,1325 @@
+package sbl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+type AliasOptions struct {
+	// Name of the alias for this scope
+	Aliasname string
+	// Key prefix used for this scope
+	Keyprefix string
+	// Force AliasBody to output $pipe command for this scope
+	ForcePipeCommand bool
+	// Force all instances of $js to use errorInfo:true for this scope
+	JSForceErrorInfo bool
+	// Disallow argument literals (${0+}, etc) for this scope
+	DisallowArgLiteral bool
+	// Minify javascript for this scope (will still be preprocessed, but not minified)
+	MinifyJS bool
+	// Compile javascript as TypeScript for this scope, like "```ts" blocks
+	TypeScript bool
+	// Do not remove temporary keys
+	KeepTempkeys bool
+	// Maximum length of the "$alias addedit" command, 0 means no limit
+	MaxLength int
+	// Fail instead of warning when the alias is longer than MaxLength
+	LengthError bool
+	// Split the alias into several aliases when it is longer than MaxLength
+	Split bool
+	// inside "get compiled", where arg literals are always disallowed
+	inCompiled bool
+	// state shared by every scope of the alias
+	state *aliasState
+}
+
+type aliasState struct {
+	// number of keys generated so far
+	generatedKeys int
+	// blocks that can be used in the alias
+	blocks map[string]*Block
+	// blocks that are currently being expanded
+	expanding map[*Block]bool
+	// messages reported while compiling, that did not stop compilation
+	diagnostics Diagnostics
+	// length of every js block compiled so far
+	jsLengths []LengthPart
+	// where gists are fetched from, cached and locked
+	gists *gistStore
+}
+
+// report a diagnostic for the alias
+func (s *aliasState) report(d Diagnostic) {
+	s.diagnostics = append(s.diagnostics, d)
+}
+
+// enterBlock finds the block for ub, it must be left with leaveBlock once expanded
+func (a *AliasOptions) enterBlock(ub *UseBlockAction) (*Block, error) {
+	block := a.shared().blocks[ub.BlockName]
+	if block == nil {
+		return nil, errorAt(Range{ub.Pos, ub.EndPos}, "unknown-block", "unknown block: %s", ub.BlockName)
+	}
+	if a.shared().expanding[block] {
+		return nil, errorAt(Range{ub.Pos, ub.EndPos}, "recursive-block", "block %s cannot use itself", ub.BlockName)
+	}
+	a.shared().expanding[block] = true
+	return block, nil
+}
+
+func (s *aliasState) leaveBlock(block *Block) {
+	delete(s.expanding, block)
+}
+
+// generatedKey returns a key (including the key prefix) that is unique within the alias
+func (a *AliasOptions) generatedKey(kind string) string {
+	key := fmt.Sprintf("%s__%s-%s%d", a.Keyprefix, a.Aliasname, kind, a.shared().generatedKeys)
+	a.shared().generatedKeys++
+	return key
+}
+
+// Copy returns a copy of the options, which shares the state of the alias with a
+func (a *AliasOptions) Copy() *AliasOptions {
+	a.shared()
+	out := *a
+	return &out
+}
+
+// shared returns the state shared by every scope of the alias. It is created
+// for options that were not made by the compiler, like &AliasOptions{...}.
+func (a *AliasOptions) shared() *aliasState {
+	if a.state == nil {
+		a.state = &aliasState{expanding: make(map[*Block]bool), gists: Options{}.gists()}
+	}
+	return a.state
+}
+
+// Getoptions returns the options for the alias, including the options set with "with"
+func (a *Alias) Getoptions() (*AliasOptions, error) {
+	return a.defaultOptions().apply(a.Options)
+}
+
+// defaultOptions returns the options for the alias, without the options set with "with"
+func (a *Alias) defaultOptions() *AliasOptions {
+	keyprefix := ""
+	if a.Keyprefix != nil {
+		keyprefix = *a.Keyprefix
+	}
+	state := &aliasState{expanding: make(map[*Block]bool), gists: Options{}.gists()}
+	if a.scope != nil {
+		state.blocks = a.scope.blocks
+	}
+	defaults := &AliasOptions{
+		Aliasname:          a.Name,
+		Keyprefix:          keyprefix,
+		ForcePipeCommand:   false,
+		JSForceErrorInfo:   true,
+		DisallowArgLiteral: false,
+		MinifyJS:           true,
+		KeepTempkeys:       false,
+		state:              state,
+	}
+	return defaults
+}
+
+// Changes AliasOptions, using an option value written in SBL
+type optionSetter struct {
+	// the values the option accepts, for errors
+	expected string
+	// set the option, returning false if the value is invalid
+	set func(a *AliasOptions, value string) bool
+}
+
+func boolOption(set func(a *AliasOptions, value bool)) optionSetter {
+	return optionSetter{"true or false", func(a *AliasOptions, value string) bool {
+		if value != "true" && value != "false" {
+			return false
+		}
+		set(a, value == "true")
+		return true
+	}}
+}
+
+func intOption(set func(a *AliasOptions, value int)) optionSetter {
+	return optionSetter{"a number", func(a *AliasOptions, value string) bool {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return false
+		}
+		set(a, n)
+		return true
+	}}
+}
+
+// Options that can be changed in SBL, and how they change AliasOptions
+var optionSetters = map[string]optionSetter{
+	"minify":      boolOption(func(a *AliasOptions, value bool) { a.MinifyJS = value }),
+	"typescript":  boolOption(func(a *AliasOptions, value bool) { a.TypeScript = value }),
+	"errorinfo":   boolOption(func(a *AliasOptions, value bool) { a.JSForceErrorInfo = value }),
+	"argliterals": boolOption(func(a *AliasOptions, value bool) { a.DisallowArgLiteral = !value }),
+	"keeptemp":    boolOption(func(a *AliasOptions, value bool) { a.KeepTempkeys = value }),
+	"forcepipe":   boolOption(func(a *AliasOptions, value bool) { a.ForcePipeCommand = value }),
+	"maxlength":   intOption(func(a *AliasOptions, value int) { a.MaxLength = value }),
+	"lengtherror": boolOption(func(a *AliasOptions, value bool) { a.LengthError = value }),
+	"split":       boolOption(func(a *AliasOptions, value bool) { a.Split = value }),
+}
+
+// Options that are about the whole alias, so they can't be changed by an "options" block
+var aliasOnlyOptions = map[string]bool{"maxlength": true, "lengtherror": true, "split": true}
+
+// apply returns a copy of the options with opts applied
+func (a *AliasOptions) apply(opts []*Option) (*AliasOptions, error) {
+	out := a.Copy()
+	for _, o := range opts {
+		setter, ok := optionSetters[o.Name]
+		if !ok {
+			names := make([]string, 0, len(optionSetters))
+			for name := range optionSetters {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, errorAt(Range{o.Pos, o.EndPos}, "unknown-option", "unknown option %q (expected one of %s)", o.Name, strings.Join(names, ", "))
+		}
+		if !setter.set(out, o.Value) {
+			return nil, errorAt(Range{o.Pos, o.EndPos}, "invalid-option", "invalid value for option %s: %q (expected %s)", o.Name, o.Value, setter.expected)
+		}
+	}
+	return out, nil
+}
+
+type Commands struct {
+	aliasCommands []string
+	// tempKeys is an array of keys that will be unset after the alias has finished
+	tempKeys []string
+}
+
+// appends c2 to the end of c1
+func (c1 *Commands) append(c2 *Commands) {
+	c1.aliasCommands = append(c1.aliasCommands, c2.aliasCommands...)
+	c1.tempKeys = append(c1.tempKeys, c2.tempKeys...)
+}
+
+// Add a single command to the commands
+func (c *Commands) add(commandString string) {
+	c.aliasCommands = append(c.aliasCommands, commandString)
+}
+
+// Add a key to be unset after the alias has finished, unless temp keys are kept for this scope
+func (c *Commands) addTempKey(a *AliasOptions, key string) {
+	if !a.KeepTempkeys {
+		c.tempKeys = append(c.tempKeys, key)
+	}
+}
+
+type CompiledAliasBody struct {
+	// alias txt
+	bodyText string
+	// keys to be unset at the end of alias execution (that havent already been unset)
+	tempKeys []string
+	// character placed between commands, empty if there is only one command
+	pipeChar string
+}
+
+// Compile the alias, if it was split into several aliases they are on separate lines.
+// The error is the Diagnostics reported while compiling, if any of them are errors.
+func (a *Alias) Compile() (string, error) {
+	compiled, diagnostics, err := a.compile(Options{})
+	if err != nil {
+		if !errors.Is(err, errReported) {
+			diagnostics = append(diagnostics, DiagnosticFromError(err))
+		}
+		return "", diagnostics
+	}
+	lines := make([]string, 0, len(compiled))
+	for _, alias := range compiled {
+		lines = append(lines, alias.Code)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// compile the alias, also returning the diagnostics reported while compiling.
+// The length limit in o is used unless the alias sets its own.
+// If the alias was split, the alias itself comes after the aliases it calls.
+func (a *Alias) compile(o Options) ([]CompiledAlias, Diagnostics, error) {
+	defaults := a.defaultOptions()
+	defaults.MaxLength = o.MaxLength
+	defaults.LengthError = o.LengthError
+	defaults.Split = o.Split
+	defaults.state.gists = o.gists()
+	opts, err := defaults.apply(a.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	compiled, err := a.compileWithOptions(opts)
+	return compiled, opts.shared().diagnostics, err
+}
+
+func (a *Alias) compileWithOptions(opts *AliasOptions) ([]CompiledAlias, error) {
+	actions, err := a.Body.compileEach(opts)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := a.compilePart(opts, a.Name, actions, 0, len(actions), "")
+	if err != nil {
+		return nil, err
+	}
+	if opts.Split && opts.MaxLength > 0 && compiled.Length > opts.MaxLength && len(actions) > 1 {
+		return a.split(opts, actions)
+	}
+	return []CompiledAlias{compiled}, a.checkLength(opts, compiled)
+}
+
+// compilePart compiles the top level actions from up to (not including) to, as the alias name.
+// If next is not empty, the part ends by running the alias next, with the same arguments if
+// a later part uses them, otherwise it is the last part, and it removes the temp keys of every part.
+func (a *Alias) compilePart(opts *AliasOptions, name string, actions []*Commands, from, to int, next string) (CompiledAlias, error) {
+	compiled := CompiledAlias{Name: name}
+	commands := joinActions(actions[from:to])
+	if next != "" {
+		// the last action of this part is followed by a null command,
+		// so only the arguments are passed to the next part
+		commands.tempKeys = nil
+		if usesArgs(actions[to:]) {
+			commands.add("$ " + next + " ${0+}")
+		} else {
+			// supibot adds the arguments to the end of an alias without arg literals,
+			// so they are only passed to the parts that use them
+			commands.add("$ " + next)
+		}
+	} else {
+		// only the root alias body removes temp keys, so that keys
+		// set by nested bodies are not removed before they are used
+		commands.tempKeys = joinActions(actions).tempKeys
+		if len(commands.tempKeys) > 0 && len(commands.aliasCommands) > 0 && !usesArgs([]*Commands{commands}) {
+			// supibot adds the arguments to the end of an alias without arg literals,
+			// which would be the command that removes the temp keys
+			commands.aliasCommands[len(commands.aliasCommands)-1] += " ${0+}"
+		}
+		commands.removeTempKeys(opts)
+	}
+	out, err := commands.pipe(opts, Range{a.Pos, a.EndPos})
+	if err != nil {
+		return compiled, err
+	}
+	if len(out.tempKeys) > 0 {
+		return compiled, errorAt(Range{a.Pos, a.EndPos}, "internal", "internal error: uncleared tempKeys: %v", out.tempKeys)
+	}
+	compiled.Code = "$alias addedit " + name + " " + out.bodyText
+	compiled.Length = textLength(compiled.Code)
+	for i := from; i < to; i++ {
+		action := a.Body.Actions[i]
+		compiled.Parts = append(compiled.Parts, LengthPart{
+			Range:  Range{action.Pos, action.EndPos},
+			Kind:   "action",
+			Length: actions[i].length(out.pipeChar),
+			Code:   strings.Join(actions[i].aliasCommands, out.pipeChar),
+		})
+	}
+	for _, part := range opts.shared().jsLengths {
+		// js blocks from other parts are left out, blocks expanded with use are not
+		// inside any action, so they are only listed when the alias is not split
+		if from == 0 && to == len(actions) || a.containsActions(from, to, part.Range) {
+			compiled.Parts = append(compiled.Parts, part)
+		}
+	}
+	return compiled, nil
+}
+
+// arg literals that use the arguments of the alias, like the ArgLiteral token without executor and channel
+var argsLiteral = regexp.MustCompile(`\$\{(\d+\+?|-?\d+|-?\d+\.\.(-?\d+)?|\d+-\d+)\}`)
+
+// usesArgs reports whether one of the compiled actions uses the arguments of the alias
+func usesArgs(actions []*Commands) bool {
+	for _, action := range actions {
+		for _, command := range action.aliasCommands {
+			if argsLiteral.MatchString(command) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsActions reports whether r is inside one of the top level actions from up to to
+func (a *Alias) containsActions(from, to int, r Range) bool {
+	for _, action := range a.Body.Actions[from:to] {
+		if r.Start.Filename == action.Pos.Filename &&
+			r.Start.Offset >= action.Pos.Offset && r.Start.Offset < action.EndPos.Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// split the alias into parts that fit in the length limit, by adding top level actions
+// to a part until the next one does not fit. Each part runs the next one, and the
+// first part is the alias itself. The parts are returned in reverse order, so an
+// alias comes after the alias it calls.
+func (a *Alias) split(opts *AliasOptions, actions []*Commands) ([]CompiledAlias, error) {
+	partName := func(n int) string {
+		if n == 1 {
+			return a.Name
+		}
+		return fmt.Sprintf("%s__part%d", a.Name, n)
+	}
+	// name of the part after part n, if it ends before action to
+	nextName := func(n, to int) string {
+		if to == len(actions) {
+			return ""
+		}
+		return partName(n + 1)
+	}
+
+	var parts []CompiledAlias
+	for from := 0; from < len(actions); {
+		n := len(parts) + 1
+		// every part has at least one action, even if it is too long by itself
+		to := from + 1
+		for to < len(actions) {
+			candidate, err := a.compilePart(opts, partName(n), actions, from, to+1, nextName(n, to+1))
+			if err != nil {
+				return nil, err
+			}
+			if candidate.Length > opts.MaxLength {
+				break
+			}
+			to++
+		}
+		part, err := a.compilePart(opts, partName(n), actions, from, to, nextName(n, to))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+		from = to
+	}
+
+	opts.shared().report(Diagnostic{
+		Severity: SeverityInfo,
+		Range:    Range{a.Pos, a.EndPos},
+		Code:     "split",
+		Message:  fmt.Sprintf("alias %s was split into %d aliases to fit in the length limit", a.Name, len(parts)),
+	})
+	var lengthErr error
+	for _, part := range parts {
+		if err := a.checkLength(opts, part); err != nil {
+			lengthErr = err
+		}
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return parts, lengthErr
+}
+
+// checkLength reports the compiled alias if it is longer than the limit,
+// the error is errReported if the alias should fail to compile
+func (a *Alias) checkLength(opts *AliasOptions, compiled CompiledAlias) error {
+	if opts.MaxLength == 0 || compiled.Length <= opts.MaxLength {
+		return nil
+	}
+	severity := SeverityWarning
+	if opts.LengthError {
+		severity = SeverityError
+	}
+	d := Diagnostic{
+		Severity: severity,
+		Range:    Range{a.Pos, a.EndPos},
+		Code:     "too-long",
+		Message:  fmt.Sprintf("alias %s is %d characters long, the limit is %d", compiled.Name, compiled.Length, opts.MaxLength),
+	}
+	for _, part := range compiled.Parts {
+		kind := part.Kind
+		if kind == "js" {
+			kind = "js block"
+		}
+		d.Notes = append(d.Notes, Note{part.Range, fmt.Sprintf("this %s adds %d characters", kind, part.Length)})
+	}
+	opts.shared().report(d)
+	if opts.LengthError {
+		return errReported
+	}
+	return nil
+}
+
+// A part of an alias, and about how many characters it adds to the compiled alias
+type LengthPart struct {
+	Range Range `json:"range"`
+	// "action" for a top level action of the alias, or "js" for a js block
+	// (js blocks are also counted in the action they are part of)
+	Kind   string `json:"kind"`
+	Length int    `json:"length"`
+	// The commands the part compiled to, joined like in the alias
+	Code string `json:"code"`
+}
+
+// textLength is the length of s as counted by javascript, which is what supibot uses
+func textLength(s string) int {
+	n := 0
+	for _, r := range s {
+		// outside the basic multilingual plane, a character is two UTF-16 code units
+		if r > 0xFFFF {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// length of the commands when joined with pipeChar
+func (c *Commands) length(pipeChar string) int {
+	n := 0
+	for _, cmd := range c.aliasCommands {
+		n += textLength(cmd) + textLength(pipeChar)
+	}
+	return n
+}
+
+// eg. "|123|" or "|"
+var pipechar = regexp.MustCompile(`\|(\d+)\||\|`)
+
+func (ab *AliasBody) Compile(a *AliasOptions) (*CompiledAliasBody, error) {
+	commands, err := ab.compileActions(a)
+	if err != nil {
+		return nil, err
+	}
+	return commands.pipe(a, Range{ab.Pos, ab.EndPos})
+}
+
+// compile actions, adding null command between them
+func (ab *AliasBody) compileActions(a *AliasOptions) (*Commands, error) {
+	actions, err := ab.compileEach(a)
+	if err != nil {
+		return nil, err
+	}
+	return joinActions(actions), nil
+}
+
+// compile every action, the separating commands are included in the commands of each action
+func (ab *AliasBody) compileEach(a *AliasOptions) ([]*Commands, error) {
+	if len(ab.Actions) == 0 {
+		return nil, errorAt(Range{ab.Pos, ab.EndPos}, "empty-body", "an alias must have at least one action")
+	}
+
+	actions := make([]*Commands, 0, len(ab.Actions))
+	failed := false
+	for i, aa := range ab.Actions {
+		cmds, err := aa.Compile(a)
+		if err != nil {
+			// keep going, so the errors of every action are reported
+			if !errors.Is(err, errReported) {
+				a.shared().report(DiagnosticFromError(err))
+			}
+			failed = true
+			continue
+		}
+		if i+1 != len(ab.Actions) && len(cmds.aliasCommands) > 0 {
+			cmds.add("abb say")
+			cmds.add("null")
+		}
+		actions = append(actions, cmds)
+	}
+	if failed {
+		return nil, errReported
+	}
+	return actions, nil
+}
+
+func joinActions(actions []*Commands) *Commands {
+	commands := &Commands{}
+	for _, cmds := range actions {
+		commands.append(cmds)
+	}
+	return commands
+}
+
+// Adds a command that unsets every temp key (once), passing through its input
+func (c *Commands) removeTempKeys(a *AliasOptions) {
+	if len(c.tempKeys) == 0 {
+		return
+	}
+	seen := make(map[string]bool)
+	deleteKeysJS := "let k = ["
+	for _, key := range c.tempKeys {
+		if seen[key] {
+			continue
+		}
+		if len(seen) > 0 {
+			deleteKeysJS += ","
+		}
+		seen[key] = true
+		escapedKey := strings.ReplaceAll(key, `"`, `\\"`)
+		deleteKeysJS += `\"` + escapedKey + `\"`
+	}
+	deleteKeysJS += `];for(let i=0;i<k.length;i++)customData.set(k[i],undefined);`
+	// args.join(' ') must be last   // TODO: Some way to passthrough text without removing params
+	deleteKeysJS += "args.join(' ');"
+	errInfo := ""
+	if a.JSForceErrorInfo {
+		errInfo = "errorInfo:true "
+	}
+	c.add(`js ` + errInfo + `function:"` + deleteKeysJS + `"`)
+	c.tempKeys = nil
+}
+
+// Joins the commands into one command, using $pipe if there is more than one.
+// r is the source of the commands, used for errors.
+func (c *Commands) pipe(a *AliasOptions, r Range) (*CompiledAliasBody, error) {
+	commands := c.aliasCommands
+	tempKeys := c.tempKeys
+	if len(commands) == 0 {
+		return nil, errorAt(r, "no-commands", "an alias must be equivelent to at least one command (your alias does nothing!)")
+	}
+
+	// used in "get compiled", must output a string ready to be input to $pipe no matter what
+	if a.ForcePipeCommand && len(commands) == 1 {
+		commands = append([]string{"null"}, commands...)
+	} else if len(commands) == 1 {
+		return &CompiledAliasBody{commands[0], tempKeys, ""}, nil
+	}
+
+	uniqueUsedPipeNums := make(map[int]bool)
+	// Find used pipe characters (meaning they exist anywhere in the commands)
+	// track the unique numbers they use
+	matches := pipechar.FindAllString(strings.Join(commands, " "), -1)
+	for _, v := range matches {
+		if v == "|" {
+			// -1 represents "|"
+			uniqueUsedPipeNums[-1] = true
+		} else {
+			numInChar, err := strconv.Atoi(v[1 : len(v)-1])
+			if err != nil {
+				return nil, fmt.Errorf("parse int in pipe char: %w", err)
+			}
+			uniqueUsedPipeNums[numInChar] = true
+		}
+	}
+
+	// pipe char is "|x|" where x is the lowest unused number
+	pipeChar := "|"
+	if len(uniqueUsedPipeNums) > 0 {
+		num := 0
+		usedPipeNums := make([]int, 0, len(uniqueUsedPipeNums))
+		for k := range uniqueUsedPipeNums {
+			usedPipeNums = append(usedPipeNums, k)
+		}
+
+		// find lowest missing int
+		sort.Slice(usedPipeNums, func(i, j int) bool {
+			return usedPipeNums[i] < usedPipeNums[j]
+		})
+		for i, v := range usedPipeNums {
+			// list    [-1 0 1 2]
+			// indexes [ 0 1 2 3]
+			// v should equal the index - 1, if nothing is missing
+			if v != i-1 {
+				num = usedPipeNums[i-1] + 1
+				break
+			} else if i == len(usedPipeNums)-1 {
+				// nothing is missing, so increase by one
+				num = v + 1
+				break
+			}
+		}
+		pipeChar = "|" + fmt.Sprint(num) + "|"
+	}
+	return &CompiledAliasBody{"pipe _char:" + pipeChar + " " + strings.Join(commands, pipeChar), tempKeys, pipeChar}, nil
+}
+func (aa *AliasAction) Compile(a *AliasOptions) (*Commands, error) {
+	out := &Commands{}
+	if aa.ExecuteAction != nil {
+		cmds, err := aa.ExecuteAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		out.append(cmds)
+	} else if aa.GetCompiledAction != nil {
+		cmds, err := aa.GetCompiledAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		out.append(cmds)
+	} else if aa.IfAction != nil {
+		cmds, err := aa.IfAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		out.append(cmds)
+	} else if aa.DispatchAction != nil {
+		cmds, err := aa.DispatchAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		out.append(cmds)
+	} else if aa.UseBlock != nil {
+		cmds, err := aa.UseBlock.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		out.append(cmds)
+	} else if aa.OptionsAction != nil {
+		cmds, err := aa.OptionsAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		out.append(cmds)
+	}
+	// if aa.ContinueAction != nil {
+	// 	cmds, err := aa.ContinueAction.Compile(a)
+	// 	if err != nil {
+	// 		return nil, err
+	// 	}
+	// 	out = append(out, cmds...)
+	// }
+	return out, nil
+}
+func (ca *GetCompiledAction) Compile(a *AliasOptions) (commands *Commands, err error) {
+	commands = &Commands{}
+	continueAction := ca.ContinueAction
+	compilationRoot := ca.CompilationRoot
+	if ca.UseBlock != nil {
+		block, err := a.enterBlock(ca.UseBlock)
+		if err != nil {
+			return nil, err
+		}
+		defer a.shared().leaveBlock(block)
+		compilationRoot = block.Body
+	}
+	if compilationRoot != nil {
+		aliasOpts := a.Copy()
+		aliasOpts.ForcePipeCommand = true
+		aliasOpts.DisallowArgLiteral = true
+		aliasOpts.inCompiled = true
+		result, err := compilationRoot.Compile(aliasOpts)
+		if err != nil {
+			return nil, err
+		}
+		commands.tempKeys = append(commands.tempKeys, result.tempKeys...)
+		execString := result.bodyText
+		if err != nil {
+			return nil, err
+		}
+
+		// escape two sets of quotes, one for function param, one for javascript string literal
+		// and remove "pipe " from the string
+		escapedString := strings.Replace(execString[5:], `\`, `\\`, -1)
+		escapedString = strings.Replace(escapedString, `"`, `\"`, -1)
+		escapedString = strings.Replace(escapedString, `'`, `\'`, -1)
+		escapedString = strings.Replace(escapedString, "\n", "", -1)
+		// escape params
+		escapedString = strings.Replace(escapedString, `:`, `'+':`, -1)
+		// escape arg literals
+		// escapedString = strings.Replace(escapedString, `${`, `$'+'{`, -1)
+		errInfo := ""
+		if a.JSForceErrorInfo {
+			errInfo = "errorInfo:true "
+		}
+		if continueAction != nil && continueAction.StoreKey != nil {
+			key := *continueAction.StoreKey
+			if continueAction.StoreKeyLocal {
+				key = a.Keyprefix + key
+			}
+			if continueAction.StoreKeyTemp {
+				commands.addTempKey(a, key)
+			}
+
+			// the key is set here, so only the rest of the chain is left
+			continueAction = continueAction.SecondContinue
+			escapedKey := strings.Replace(key, `"`, `\\"`, -1)
+			commands.add("js " + errInfo + "function:\" customData.set(\\\"" + escapedKey + "\\\",'" + escapedString + "') \"")
+		} else {
+			commands.add("js " + errInfo + "function:\" '" + escapedString + "' \"")
+		}
+	}
+	if continueAction != nil {
+		cmds, err := continueAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		commands.append(cmds)
+	}
+	return
+}
+
+// Compiles the actions in place of this action, with the options changed
+func (oa *OptionsAction) Compile(a *AliasOptions) (*Commands, error) {
+	for _, o := range oa.Options {
+		if aliasOnlyOptions[o.Name] {
+			return nil, errorAt(Range{o.Pos, o.EndPos}, "invalid-option", "option %s can only be set for the whole alias, with \"with\"", o.Name)
+		}
+		if o.Name == "argliterals" && o.Value == "true" && a.inCompiled {
+			return nil, errorAt(Range{o.Pos, o.EndPos}, "invalid-option", "arg literals can't be allowed inside \"get compiled\"")
+		}
+	}
+	opts, err := a.apply(oa.Options)
+	if err != nil {
+		return nil, err
+	}
+	cmds, err := (&AliasBody{Pos: oa.Pos, EndPos: oa.EndPos, Actions: oa.Actions}).compileActions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// Expands the block inline, compiled with the options of the caller.
+// The commands of the block are part of the caller's commands, like an "options" block.
+func (ub *UseBlockAction) Compile(a *AliasOptions) (*Commands, error) {
+	block, err := a.enterBlock(ub)
+	if err != nil {
+		return nil, err
+	}
+	defer a.shared().leaveBlock(block)
+	return block.Body.compileActions(a)
+}
+func (ea *ExecuteAction) Compile(a *AliasOptions) (*Commands, error) {
+	commands := Commands{}
+	if ea.RetrieveAction != nil {
+		cmds, err := ea.RetrieveAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		commands.append(cmds)
+	}
+	if ea.SimpleAction != nil {
+		cmds, err := ea.SimpleAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		commands.append(cmds)
+	}
+	if ea.ContinueAction != nil {
+		cmds, err := ea.ContinueAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		commands.append(cmds)
+	}
+	return &commands, nil
+}
+func (ra *RetrieveAction) Compile(a *AliasOptions) (commands *Commands, err error) {
+	commands = &Commands{}
+	if ra.RetrieveKey != nil {
+		key := *ra.RetrieveKey
+		if ra.LocalRetrieveKey {
+			key = a.Keyprefix + *ra.RetrieveKey
+		}
+
+		escapedKey := strings.Replace(key, `"`, `\\"`, -1)
+		errInfo := ""
+		if a.JSForceErrorInfo {
+			errInfo = "errorInfo:true "
+		}
+		commands.add(`js ` + errInfo + `function:"customData.get(\"` + escapedKey + `\")"`)
+	} else if ra.RetrieveArgs != nil {
+		if a.DisallowArgLiteral {
+			// can be disabled because "get compiled" will mess with them, making them unreliable
+			return nil, errorAt(Range{ra.Pos, ra.EndPos}, "arg-literal-not-allowed", "arg literals are not allowed in this context")
+		}
+		commands.add(`abb say ` + *ra.RetrieveArgs)
+	}
+	return
+}
+func (ca *ContinuedAction) Compile(a *AliasOptions) (commands *Commands, err error) {
+	commands = &Commands{}
+	if ca.StoreKey != nil {
+		key := *ca.StoreKey
+		if ca.StoreKeyLocal {
+			key = a.Keyprefix + *ca.StoreKey
+		}
+		if ca.StoreKeyTemp {
+			commands.addTempKey(a, key)
+		}
+
+		escapedKey := strings.Replace(key, `"`, `\\"`, -1)
+		errInfo := ""
+		if a.JSForceErrorInfo {
+			errInfo = "errorInfo:true "
+		}
+		commands.add(`js ` + errInfo + `function:"customData.set(\"` + escapedKey + `\", args.join(' '))"`)
+	} else if ca.NextAction != nil {
+		cmds, err := ca.NextAction.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		commands.append(cmds)
+	}
+	if ca.SecondContinue != nil {
+		cmds, err := ca.SecondContinue.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		commands.append(cmds)
+	}
+	return
+}
+func (ea *ExecuteActionSimple) Compile(a *AliasOptions) (*Commands, error) {
+	out := &Commands{}
+	if ea.JSExec != nil {
+		cmds, err := ea.JSExec.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		out.append(cmds)
+	} else if ea.PipeCommandLiterals != nil {
+		out.aliasCommands = append(out.aliasCommands, ea.PipeCommandLiterals...)
+	} else if ea.CallAlias != nil {
+		cmds, err := ea.CallAlias.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		out.append(cmds)
+	} else if ea.UseSayLiteral {
+		// since say will just output its input, we can optimize it out
+		// as long as you dont append any text
+		if ea.SayLiteral != nil {
+			out.add("abb say " + *ea.SayLiteral)
+		}
+	} else {
+		return nil, errorAt(Range{ea.Pos, ea.EndPos}, "internal", "invalid ExecuteActionSimple")
+	}
+	return out, nil
+}
+func (jsa *JSExecAction) Compile(a *AliasOptions) (commands *Commands, err error) {
+	commands = &Commands{}
+	// Calculate injected Javascript
+	unescapedJSCode := strings.Replace(jsa.ExecString.RawString, "\\`", "`", -1)
+	// the types of TypeScript are removed by esbuild, not checked
+	loader := esbuild.LoaderJS
+	unescapedJSCode, typescript := typeScriptBlock(unescapedJSCode)
+	if typescript || a.TypeScript {
+		loader = esbuild.LoaderTS
+	}
+
+	escapedKeyprefix := strings.Replace(a.Keyprefix, `"`, `\"`, -1)
+	escapedKeyprefix = strings.Replace(escapedKeyprefix, "\n", "\\n", -1)
+	// runtime functions to interact with local keys
+	injectedRuntime := `
+		// get the local value for the key
+		function getLocal(key) {
+			return customData.get("` + escapedKeyprefix + `"+key)
+		}
+		// set the local value for the key
+		function setLocal(key, value) {
+			return customData.set("` + escapedKeyprefix + `"+key, value)
+		}
+		// get the local key prefix
+		function getLocalPrefix() {
+			return "` + escapedKeyprefix + `"
+		}
+`
+	// injected gists, local modules are bundled instead
+	injectedGistsContent := []string{}
+	modules := []string{}
+	for _, name := range jsa.InjectedGists {
+		if isLocalModule(name) {
+			modules = append(modules, name)
+			continue
+		}
+		content, err := jsa.gistContent(a, name, false)
+		if err != nil {
+			return nil, err
+		}
+		injectedGistsContent = append(injectedGistsContent, content)
+	}
+	injectedGistJS := strings.Join(injectedGistsContent, "\n\n")
+	// Final injected code
+	injectedCode := injectedGistJS + "\n\n" + injectedRuntime + "\n\n" + jsa.prefix
+	suffix := jsa.suffix
+	if len(modules) > 0 {
+		// a bundle is a module, which can't return outside of a function
+		injectedCode = injectedGistJS + "\n\n" + injectedRuntime + "\n\n(()=>{" + jsa.prefix
+		suffix += "\n})();"
+	}
+	var code []byte
+	var errs, warnings []esbuild.Message
+	if len(modules) > 0 {
+		code, errs, warnings, err = bundleJS(jsa.Pos.Filename, injectedCode+unescapedJSCode+suffix, modules, loader, a.MinifyJS)
+		if err != nil {
+			return nil, errorAt(Range{jsa.Pos, jsa.ExecString.Pos}, "module", "bundle modules: %s", err.Error())
+		}
+	} else {
+		// Minify code so that it can fit on one line, because I'm not parsing that shit
+		res := esbuild.Transform(injectedCode+unescapedJSCode+suffix, esbuild.TransformOptions{
+			Loader:            loader,
+			Drop:              esbuild.DropConsole, // console doesnt even exist in $js
+			IgnoreAnnotations: true,
+			// Tree shake to remove our runtime if it doesnt get used
+			TreeShaking: esbuild.TreeShakingTrue,
+			// always minify whitespace to trim newlines
+			MinifyWhitespace:  true,
+			MinifyIdentifiers: a.MinifyJS,
+			MinifySyntax:      a.MinifyJS,
+		})
+		code, errs, warnings = res.Code, res.Errors, res.Warnings
+	}
+
+	if len(errs) > 0 || len(warnings) > 0 {
+		position := func(l lexer.Position, l2 *esbuild.Location) Range {
+			if l2 == nil {
+				return pointRange(jsa.Pos)
+			}
+			if len(modules) > 0 && l2.File != l.Filename {
+				// in a bundled module
+				start := lexer.Position{Filename: l2.File, Line: l2.Line, Column: l2.Column + 1}
+				end := start
+				end.Column += l2.Length
+				return Range{start, end}
+			}
+			// calculate the actual locaiton of l2 in our source file
+			// based on where the js token started
+			var loc lexer.Position
+			injectedLines := strings.Split(injectedCode, "\n")
+			// If its the first line of where the user wrote....
+			if l2.Line-len(injectedLines)+1 == 1 {
+				lenLastInjectedLine := len(injectedLines[len(injectedLines)-1])
+				loc.Column =
+					//  text within source file, before js starts
+					l.Column + len("```") +
+						//  text written after the backtics
+						(l2.Column - lenLastInjectedLine) +
+						//  offset for escaping the backtic character with backslash
+						strings.Count(l2.LineText[lenLastInjectedLine:], "`")
+			} else {
+				// add one for every backtic, because those are written as "\`"
+				loc.Column = l2.Column + 1 + strings.Count(l2.LineText, "`")
+			}
+			loc.Filename = l.Filename
+			loc.Line = l.Line + l2.Line - len(injectedLines)
+			end := loc
+			end.Column += l2.Length
+			return Range{loc, end}
+		}
+		report := func(severity Severity, messages []esbuild.Message) {
+			for _, m := range messages {
+				d := Diagnostic{
+					Severity: severity,
+					Range:    position(jsa.ExecString.Pos, m.Location),
+					Code:     "javascript",
+					Message:  m.Text,
+				}
+				for _, n := range m.Notes {
+					d.Notes = append(d.Notes, Note{position(jsa.ExecString.Pos, n.Location), n.Text})
+				}
+				a.shared().report(d)
+			}
+		}
+		report(SeverityWarning, warnings)
+		report(SeverityError, errs)
+		if len(errs) > 0 {
+			return nil, errReported
+		}
+	}
+
+	// this string must not start or end with a double quote
+	// supibot trims them, thinking they are part of the parameter.
+	minifiedCode := `(()=>{` + string(code) + `})();`
+	if len(modules) > 0 {
+		// the function is already wrapped, and the result is the value of the last statement
+		minifiedCode = strings.TrimSpace(string(code))
+	}
+
+	// Escape quote for funciton param
+	escapedMinifiedCode := strings.Replace(minifiedCode, `\`, `\\`, -1)
+	escapedMinifiedCode = strings.Replace(escapedMinifiedCode, `"`, `\"`, -1)
+	// remove newlines (just in case there is any, for some reason)
+	escapedMinifiedCode = strings.Replace(escapedMinifiedCode, "\n", "", -1)
+	errInfo := ""
+	if a.JSForceErrorInfo {
+		errInfo = "errorInfo:true "
+	}
+	importGist := ""
+	if jsa.ImportedGist != nil {
+		if strings.Contains(*jsa.ImportedGist, "#") {
+			return nil, errorAt(Range{jsa.Pos, jsa.ExecString.Pos}, "invalid-gist-id", "supibot runs the only file of an imported gist, a file can't be chosen with \"#\"")
+		}
+		if err := validateGistID(*jsa.ImportedGist); err != nil {
+			return nil, errorAt(Range{jsa.Pos, jsa.ExecString.Pos}, "invalid-gist-id", err.Error())
+		}
+		// fetching the gist checks that supibot can import it, and caches it for the emulator
+		if _, err := jsa.gistContent(a, *jsa.ImportedGist, true); err != nil {
+			return nil, err
+		}
+		importGist = "importGist:" + *jsa.ImportedGist + " "
+	}
+	commands = &Commands{}
+	commands.add("js " + errInfo + importGist + "function:\"" + escapedMinifiedCode + "\"")
+	a.shared().jsLengths = append(a.shared().jsLengths, LengthPart{
+		Range:  Range{jsa.Pos, jsa.EndPos},
+		Kind:   "js",
+		Length: textLength(commands.aliasCommands[0]),
+		Code:   commands.aliasCommands[0],
+	})
+	return commands, nil
+}
+
+// gistContent returns the content of a gist ("id", or "id#file" to choose a file), reporting warnings from fetching it.
+// imported is true for a gist that supibot imports, instead of one that is injected.
+func (jsa *JSExecAction) gistContent(a *AliasOptions, ref string, imported bool) (string, error) {
+	content, warnings, err := a.shared().gists.content(ref)
+	for _, w := range warnings {
+		a.shared().report(Diagnostic{
+			Severity: SeverityWarning,
+			Range:    Range{jsa.Pos, jsa.ExecString.Pos},
+			Code:     "gist",
+			Message:  fmt.Sprintf("gist %s: %s", ref, w),
+		})
+	}
+	if err != nil {
+		code, message := "gist", err.Error()
+		if errors.Is(err, ErrOffline) {
+			code = "offline"
+		}
+		if errors.Is(err, errTooManyFiles) && imported {
+			message += ", supibot can only import a gist with one text/plain or application/javascript file"
+		} else if errors.Is(err, errTooManyFiles) {
+			message += ", choose one with \"id#file\""
+		}
+		return "", errorAt(Range{jsa.Pos, jsa.ExecString.Pos}, code, "get gist %s: %s", ref, message)
+	}
+	return content, nil
+}
+
+// $pipe input that does nothing, used when no branch is taken
+const noopPipeInput = "null | null"
+
+// Stores a branch with "get compiled" in a generated temp key, returning
+// javascript that evaluates to the $pipe input of the branch
+func (c *Commands) storeBranch(a *AliasOptions, branch *AliasBody, kind string) (string, error) {
+	if len(branch.Actions) == 0 {
+		return jsString(noopPipeInput), nil
+	}
+	key := a.generatedKey(kind)
+	store := &GetCompiledAction{
+		CompilationRoot: branch,
+		ContinueAction:  &ContinuedAction{StoreKeyTemp: true, StoreKey: &key},
+	}
+	cmds, err := store.Compile(a)
+	if err != nil {
+		return "", err
+	}
+	c.append(cmds)
+	c.add("abb say")
+	c.add("null")
+	return "customData.get(" + jsString(key) + ")", nil
+}
+
+// Selects a stored branch using javascript, and executes it with $pipe
+func (c *Commands) selectBranch(a *AliasOptions, input *RetrieveAction, selector *JSExecAction, ca *ContinuedAction) error {
+	selectAction := &ExecuteAction{
+		RetrieveAction: input,
+		SimpleAction:   &ExecuteActionSimple{Pos: selector.Pos, JSExec: selector},
+		ContinueAction: &ContinuedAction{
+			NextAction:     &ExecuteActionSimple{PipeCommandLiterals: []string{"pipe"}},
+			SecondContinue: ca,
+		},
+	}
+	cmds, err := selectAction.Compile(a)
+	if err != nil {
+		return err
+	}
+	c.append(cmds)
+	return nil
+}
+
+func (ia *IfAction) Compile(a *AliasOptions) (*Commands, error) {
+	commands := &Commands{}
+	thenJS, err := commands.storeBranch(a, &AliasBody{Pos: ia.Pos, EndPos: ia.EndPos, Actions: ia.Then}, "if-then")
+	if err != nil {
+		return nil, err
+	}
+	elseJS, err := commands.storeBranch(a, &AliasBody{Pos: ia.Pos, EndPos: ia.EndPos, Actions: ia.Else}, "if-else")
+	if err != nil {
+		return nil, err
+	}
+	err = commands.selectBranch(a, ia.RetrieveAction, &JSExecAction{
+		Pos:        ia.Condition.Pos,
+		ExecString: ia.Condition,
+		prefix:     "return (",
+		suffix:     ")\n? " + thenJS + "\n: " + elseJS,
+	}, ia.ContinueAction)
+	if err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+func (da *DispatchAction) Compile(a *AliasOptions) (*Commands, error) {
+	commands := &Commands{}
+	selector := "switch (args.join(\" \")) {\n"
+	seen := make(map[string]bool)
+	var emptyCase, defaultCase *DispatchCase
+	for _, dc := range da.Cases {
+		if dc.Empty {
+			if emptyCase != nil {
+				return nil, errorAt(Range{dc.Pos, dc.EndPos}, "duplicate-case", "only one empty case can be specified per dispatch")
+			}
+			emptyCase = dc
+			continue
+		} else if dc.Default {
+			if defaultCase != nil {
+				return nil, errorAt(Range{dc.Pos, dc.EndPos}, "duplicate-case", "only one default case can be specified per dispatch")
+			}
+			defaultCase = dc
+			continue
+		}
+		branchJS, err := commands.storeBranch(a, dc.body(), "dispatch-case")
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range dc.Values {
+			if value == "" {
+				return nil, errorAt(Range{dc.Pos, dc.EndPos}, "empty-case", "a case cannot be the empty string, use the empty case instead")
+			}
+			if seen[value] {
+				return nil, errorAt(Range{dc.Pos, dc.EndPos}, "duplicate-case", "duplicate case: %q", value)
+			}
+			seen[value] = true
+			selector += "case " + jsString(value) + ":\n"
+		}
+		selector += "return " + branchJS + "\n"
+	}
+	defaultJS := jsString(noopPipeInput)
+	if defaultCase != nil {
+		var err error
+		defaultJS, err = commands.storeBranch(a, defaultCase.body(), "dispatch-default")
+		if err != nil {
+			return nil, err
+		}
+	}
+	// with no empty case, empty input is handled by the default case
+	if emptyCase != nil {
+		emptyJS, err := commands.storeBranch(a, emptyCase.body(), "dispatch-empty")
+		if err != nil {
+			return nil, err
+		}
+		selector += "case \"\":\nreturn " + emptyJS + "\n"
+	}
+	selector += "default:\nreturn " + defaultJS + "\n}"
+
+	err := commands.selectBranch(a, da.Subject, &JSExecAction{
+		Pos:        da.Pos,
+		ExecString: JSExecString{Pos: da.Pos, RawString: selector},
+	}, da.ContinueAction)
+	if err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// body returns the actions of the case as an alias body
+func (dc *DispatchCase) body() *AliasBody {
+	return &AliasBody{Pos: dc.Pos, EndPos: dc.EndPos, Actions: dc.Actions}
+}
+
+// jsString quotes s as a javascript string literal
+func jsString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+func (ca *CallAliasAction) Compile(a *AliasOptions) (commands *Commands, err error) {
+	commands = &Commands{}
+	if ca.User != nil {
+		commands.add(`alias try ` + *ca.User + ` ` + ca.AliasName)
+	} else {
+		commands.add(`$ ` + ca.AliasName)
+	}
+	return
+}
+
+// calledAliases lists the names of own aliases called from inside the body,
+// including the blocks it uses (which must not use themselves)
+func (ab *AliasBody) calledAliases(blocks map[string]*Block) (names []string) {
+	for _, aa := range ab.Actions {
+		if aa.ExecuteAction != nil {
+			names = append(names, aa.ExecuteAction.SimpleAction.calledAliases()...)
+			names = append(names, aa.ExecuteAction.ContinueAction.calledAliases(blocks)...)
+		} else if aa.GetCompiledAction != nil {
+			if aa.GetCompiledAction.UseBlock != nil {
+				names = append(names, aa.GetCompiledAction.UseBlock.calledAliases(blocks)...)
+			} else {
+				names = append(names, aa.GetCompiledAction.CompilationRoot.calledAliases(blocks)...)
+			}
+			names = append(names, aa.GetCompiledAction.ContinueAction.calledAliases(blocks)...)
+		} else if aa.IfAction != nil {
+			names = append(names, (&AliasBody{Actions: aa.IfAction.Then}).calledAliases(blocks)...)
+			names = append(names, (&AliasBody{Actions: aa.IfAction.Else}).calledAliases(blocks)...)
+			names = append(names, aa.IfAction.ContinueAction.calledAliases(blocks)...)
+		} else if aa.DispatchAction != nil {
+			for _, dc := range aa.DispatchAction.Cases {
+				names = append(names, (&AliasBody{Actions: dc.Actions}).calledAliases(blocks)...)
+			}
+			names = append(names, aa.DispatchAction.ContinueAction.calledAliases(blocks)...)
+		} else if aa.UseBlock != nil {
+			names = append(names, aa.UseBlock.calledAliases(blocks)...)
+		} else if aa.OptionsAction != nil {
+			names = append(names, (&AliasBody{Actions: aa.OptionsAction.Actions}).calledAliases(blocks)...)
+		}
+	}
+	return
+}
+
+func (ub *UseBlockAction) calledAliases(blocks map[string]*Block) []string {
+	if block := blocks[ub.BlockName]; block != nil {
+		return block.Body.calledAliases(blocks)
+	}
+	return nil
+}
+
+func (ca *ContinuedAction) calledAliases(blocks map[string]*Block) (names []string) {
+	if ca == nil {
+		return nil
+	}
+	names = append(names, ca.NextAction.calledAliases()...)
+	return append(names, ca.SecondContinue.calledAliases(blocks)...)
+}
+
+func (ea *ExecuteActionSimple) calledAliases() []string {
+	if ea == nil || ea.CallAlias == nil || ea.CallAlias.User != nil {
+		return nil
+	}
+	return []string{ea.CallAlias.AliasName}
+}
+
+var processToken = func(trimleft, quotesize int, unquote bool, types ...string) func(p *participle.Parser) error {
+	unquoteWithChar := func(s string) (string, error) {
+		quote := s[trimleft]
+		s = s[trimleft+quotesize : len(s)-quotesize]
+		out := ""
+		for s != "" {
+			if unquote {
+				value, _, tail, err := strconv.UnquoteChar(s, quote)
+				if err != nil {
+					return "", err
+				}
+				s = tail
+				out += string(value)
+			} else {
+				out += s
+				s = ""
+			}
+		}
+		return out, nil
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	return participle.Map(func(t lexer.Token) (lexer.Token, error) {
+		value, err := unquoteWithChar(t.Value)
+		if err != nil {
+			return t, participle.Errorf(t.Pos, "invalid quoted string %q: %s", t.Value, err.Error())
+		}
+		t.Value = value
+		return t, nil
+	}, types...)
+}
@@ -0,0 +1,87 @@
+package sbl_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+func TestTempKeysKeepArguments(t *testing.T) {
+	src := `
+alias tk
+	say "hi" -> set temp "k"
+	exec "abb say got:"
+end
+
+test "the arguments go to the last command"
+	run tk "world"
+	expect output "got: world"
+	expect unset "k"
+end
+`
+	runTests(t, src, sbl.Options{})
+}
+
+func TestCompileCalled(t *testing.T) {
+	src := `
+alias first
+	call second
+end
+
+alias second
+	call third
+end
+
+alias third
+	say "third"
+end
+
+alias unused
+	say "unused"
+end
+`
+	ast, err := sbl.Parse("test.sbl", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := sbl.Compile(ast, sbl.Options{Entry: "first", Called: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, alias := range res.Aliases {
+		names = append(names, alias.Name)
+	}
+	if strings.Join(names, " ") != "third second first" {
+		t.Errorf("compiled %v, want the called aliases before the entry", names)
+	}
+}
+
+func TestCompileBodyWithOwnOptions(t *testing.T) {
+	src := "alias xx\n\tjs ```return 1```\n\t${0} -> if ```args[0] == \"a\"```\n\t\tsay \"a\"\n\tend\nend\n"
+	ast, err := sbl.Parse("test.sbl", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ast.Declarations[0].Alias.Body.Compile(&sbl.AliasOptions{Aliasname: "xx", MinifyJS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body == nil {
+		t.Error("the body was not compiled")
+	}
+}
+
+func TestAliasCompileDiagnostics(t *testing.T) {
+	ast, err := sbl.Parse("test.sbl", []byte("alias xx\n\tuse missing\nend\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ast.Declarations[0].Alias.Compile()
+	var diagnostics sbl.Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 1 || diagnostics[0].Code != "unknown-block" {
+		t.Errorf("got %v, want the unknown block diagnostic", err)
+	}
+}
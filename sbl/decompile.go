@@ -0,0 +1,336 @@
+package sbl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+// Decompile converts alias definitions back to SBL, one alias per line of src.
+// A line is either an "$alias addedit name ..." command, or the body of an alias
+// (what comes after the name), which is given the name defaultName.
+func Decompile(src string, defaultName string) (string, error) {
+	aliases := []string{}
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, body := defaultName, line
+		if match := aliasDefinition.FindStringSubmatch(line); match != nil {
+			name, body = match[1], match[2]
+		}
+		alias, err := decompileAlias(name, body)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", i+1, err)
+		}
+		aliases = append(aliases, alias)
+	}
+	if len(aliases) == 0 {
+		return "", errors.New("there are no aliases to decompile")
+	}
+	return strings.Join(aliases, "\n\n") + "\n", nil
+}
+
+// eg. "$alias addedit xd ping"
+var aliasDefinition = regexp.MustCompile(`^\s*\$?alias\s+(?:add|addedit|create|edit|upsert)\s+(\S+)\s+(.*)$`)
+
+// A command of the alias, and how it was decompiled
+type decompiledCommand struct {
+	// SBL for the command as a simple action, eg. `exec "ping"`
+	action string
+	// SBL for the command at the start of a chain, if it can only be used there, eg. `get "key"`
+	retrieve string
+	// key that the command stores its input in, if it is a set
+	setKey string
+	// the command is exec, so it can be merged with other exec commands
+	exec string
+	// actions of a "get compiled" action, if the command is one (only at the start of a chain)
+	compiled [][]decompiledCommand
+	// key the "get compiled" action is stored in, empty if it is output instead
+	compiledKey string
+}
+
+// a js command without errorInfo:true, also inside "get compiled"
+var jsWithoutErrorInfo = regexp.MustCompile(`js function(?:'\+')?:`)
+
+func decompileAlias(name, body string) (string, error) {
+	commands := splitPipe(body)
+
+	// the compiler removes temp keys with a command at the end of the alias
+	tempKeys := make(map[string]bool)
+	if len(commands) > 1 {
+		if keys, ok := tempKeyCleanup(commands[len(commands)-1]); ok {
+			for _, key := range keys {
+				tempKeys[key] = true
+			}
+			commands = commands[:len(commands)-1]
+		}
+	}
+
+	lines := bodyLines(splitActions(commands), tempKeys, "\t")
+	if len(lines) == 0 {
+		return "", fmt.Errorf("alias %s does nothing", name)
+	}
+	out := "alias " + name
+	if jsWithoutErrorInfo.MatchString(body) && !strings.Contains(body, "errorInfo:true") {
+		out += " with errorinfo=false"
+	}
+	return out + "\n" + strings.Join(lines, "\n") + "\nend", nil
+}
+
+// splitActions decompiles the commands, independent actions are separated by "abb say" and "null"
+func splitActions(commands []string) [][]decompiledCommand {
+	actions := [][]decompiledCommand{{}}
+	for i := 0; i < len(commands); i++ {
+		if strings.TrimSpace(commands[i]) == "abb say" && i+1 < len(commands) && strings.TrimSpace(commands[i+1]) == "null" {
+			actions = append(actions, []decompiledCommand{})
+			i++
+			continue
+		}
+		last := len(actions) - 1
+		actions[last] = append(actions[last], decompileCommand(commands[i]))
+	}
+	return actions
+}
+
+// bodyLines returns a line for each action, indented with indent
+func bodyLines(actions [][]decompiledCommand, tempKeys map[string]bool, indent string) []string {
+	lines := []string{}
+	for _, action := range actions {
+		if len(action) > 0 {
+			lines = append(lines, indent+chain(action, tempKeys, indent))
+		}
+	}
+	return lines
+}
+
+// chain joins the commands of an action with "->"
+func chain(commands []decompiledCommand, tempKeys map[string]bool, indent string) string {
+	set := func(key string) string {
+		if tempKeys[key] {
+			return "set temp " + quote(key)
+		}
+		return "set " + quote(key)
+	}
+	parts := []string{}
+	for i := 0; i < len(commands); i++ {
+		c := commands[i]
+		switch {
+		case c.compiled != nil && i == 0:
+			body := bodyLines(c.compiled, tempKeys, indent+"\t")
+			parts = append(parts, "get compiled\n"+strings.Join(body, "\n")+"\n"+indent+"end")
+			if c.compiledKey != "" {
+				parts = append(parts, set(c.compiledKey))
+			}
+		case c.retrieve != "" && i == 0 && len(commands) > 1:
+			parts = append(parts, c.retrieve)
+			// a chain needs a simple action before a set
+			if commands[1].setKey != "" {
+				parts = append(parts, "say")
+			}
+		case c.setKey != "" && i > 0:
+			parts = append(parts, set(c.setKey))
+		case c.exec != "":
+			// exec "a" | "b"
+			execs := []string{quote(c.exec)}
+			for i+1 < len(commands) && commands[i+1].exec != "" {
+				i++
+				execs = append(execs, quote(commands[i].exec))
+			}
+			parts = append(parts, "exec "+strings.Join(execs, " | "))
+		default:
+			parts = append(parts, c.action)
+		}
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// splitPipe splits the commands of a pipe, anything else is a single command
+func splitPipe(body string) []string {
+	body = strings.TrimLeft(body, " \t")
+	if !strings.HasPrefix(body, "pipe ") {
+		return []string{body}
+	}
+	rest := strings.TrimLeft(body[len("pipe "):], " \t")
+	char := "|"
+	if strings.HasPrefix(rest, "_char:") {
+		end := strings.IndexAny(rest, " \t")
+		if end == -1 {
+			end = len(rest)
+		}
+		char = rest[len("_char:"):end]
+		rest = rest[end:]
+	}
+	commands := []string{}
+	for _, command := range strings.Split(rest, char) {
+		// trailing spaces are kept, they are part of the text for commands like say
+		commands = append(commands, strings.TrimLeft(command, " \t"))
+	}
+	return commands
+}
+
+var (
+	sayArgLiteral  = regexp.MustCompile(`^abb say (\$\{[^}]+\})$`)
+	callAlias      = regexp.MustCompile(`^\$ (\S+)$`)
+	tryAlias       = regexp.MustCompile(`^alias try (\S+) (\S+)$`)
+	jsCommand      = regexp.MustCompile(`^js (?:errorInfo:(true|false) )?(?:importGist:([0-9a-fA-F]+) )?function:"(.*)"$`)
+	storeCompiled  = regexp.MustCompile(`^ customData\.set\(\\"((?:[^\\]|\\[^"])*)\\",'(.*)'\) $`)
+	outputCompiled = regexp.MustCompile(`^ '(.*)' $`)
+	getKey         = regexp.MustCompile(`^customData\.get\("((?:[^"\\]|\\.)*)"\)$`)
+	setKey         = regexp.MustCompile(`^customData\.set\("((?:[^"\\]|\\.)*)", args\.join\(' '\)\)$`)
+)
+
+func decompileCommand(command string) decompiledCommand {
+	if match := sayArgLiteral.FindStringSubmatch(command); match != nil {
+		return decompiledCommand{retrieve: match[1], action: "say " + quote(match[1])}
+	}
+	if command == "abb say" {
+		return decompiledCommand{action: "say"}
+	}
+	if strings.HasPrefix(command, "abb say ") {
+		return decompiledCommand{action: "say " + quote(command[len("abb say "):])}
+	}
+	if match := callAlias.FindStringSubmatch(command); match != nil {
+		return decompiledCommand{action: "call " + match[1]}
+	}
+	if match := tryAlias.FindStringSubmatch(command); match != nil {
+		return decompiledCommand{action: "call @" + match[1] + " " + match[2]}
+	}
+	// spaces after the function parameter are not part of it, like in "js function:"..." | abb say"
+	if match := jsCommand.FindStringSubmatch(strings.TrimRight(command, " \t")); match != nil && !hasUnescapedQuote(match[3]) {
+		if match[2] == "" {
+			if compiled := storeCompiled.FindStringSubmatch(match[3]); compiled != nil {
+				return decompiledCommand{
+					action:      jsBlock(unescapeParam(match[3])),
+					compiled:    decompileCompiled(compiled[2]),
+					compiledKey: unescapeKey(compiled[1]),
+				}
+			}
+			if compiled := outputCompiled.FindStringSubmatch(match[3]); compiled != nil {
+				return decompiledCommand{
+					action:   jsBlock(unescapeParam(match[3])),
+					compiled: decompileCompiled(compiled[1]),
+				}
+			}
+		}
+		code := unescapeParam(match[3])
+		c := decompiledCommand{}
+		if key := getKey.FindStringSubmatch(code); key != nil {
+			c.retrieve = "get " + quote(unescapeKey(key[1]))
+		}
+		if key := setKey.FindStringSubmatch(code); key != nil {
+			c.setKey = unescapeKey(key[1])
+		}
+		block := jsBlock(code)
+		if block == "" {
+			// statements can't be returned, but run as they are with exec
+			return decompiledCommand{exec: command}
+		}
+		if match[2] != "" {
+			block = "js import " + quote(match[2]) + block[len("js"):]
+		}
+		c.action = block
+		return c
+	}
+	return decompiledCommand{exec: command}
+}
+
+// jsBlock returns a js block that outputs the same as the code of a $js function,
+// or an empty string if the code isn't wrapped in a function expression like the compiler does,
+// and isn't an expression either
+func jsBlock(code string) string {
+	if strings.HasPrefix(code, "(()=>{") && strings.HasSuffix(code, "})();") {
+		code = code[len("(()=>{") : len(code)-len("})();")]
+	} else {
+		// $js outputs the value of the last statement, which is returned in a block
+		expr := strings.TrimSuffix(strings.TrimSpace(code), ";")
+		res := esbuild.Transform("("+expr+"\n)", esbuild.TransformOptions{Loader: esbuild.LoaderJS})
+		if len(res.Errors) > 0 {
+			return ""
+		}
+		code = "return (" + expr + ")"
+	}
+	return "js ```" + strings.ReplaceAll(code, "`", "\\`") + "```"
+}
+
+// decompileCompiled decompiles the actions of a "get compiled" action,
+// from the escaped javascript string it is stored as
+func decompileCompiled(escaped string) [][]decompiledCommand {
+	escaped = strings.ReplaceAll(escaped, `'+':`, `:`)
+	var body strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '\\' && i+1 < len(escaped) {
+			i++
+		}
+		body.WriteByte(escaped[i])
+	}
+	commands := splitPipe("pipe " + body.String())
+	// a single command is forced to be a pipe by adding null before it
+	if len(commands) == 2 && strings.TrimSpace(commands[0]) == "null" {
+		commands = commands[1:]
+	}
+	return splitActions(commands)
+}
+
+// tempKeyCleanup returns the keys removed by the command, if it is the command
+// the compiler adds to remove temp keys
+func tempKeyCleanup(command string) ([]string, bool) {
+	match := jsCommand.FindStringSubmatch(command)
+	if match == nil {
+		return nil, false
+	}
+	code := unescapeParam(match[3])
+	if !strings.HasPrefix(code, "let k = [") || !strings.HasSuffix(code, "args.join(' ');") {
+		return nil, false
+	}
+	end := strings.Index(code, "];")
+	if end == -1 {
+		return nil, false
+	}
+	keys := []string{}
+	if err := json.Unmarshal([]byte(code[len("let k = "):end+1]), &keys); err != nil {
+		return nil, false
+	}
+	return keys, true
+}
+
+// unescapeParam reverses the escaping the compiler does for the function parameter
+func unescapeParam(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '\\' || s[i+1] == '"') {
+			i++
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// hasUnescapedQuote reports whether s has a quote that is not escaped with a backslash,
+// which would end the parameter
+func hasUnescapedQuote(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == '"' {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeKey reverses the escaping of a key in a javascript string
+func unescapeKey(s string) string {
+	return strings.ReplaceAll(s, `\"`, `"`)
+}
+
+// quote a string for SBL
+func quote(s string) string {
+	return strconv.Quote(s)
+}
@@ -0,0 +1,79 @@
+package sbl_test
+
+import (
+	"testing"
+
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+// roundTrip decompiles the aliases, and runs the tests in tests against the result
+func roundTrip(t *testing.T, aliases, tests string) {
+	t.Helper()
+	src, err := sbl.Decompile(aliases, "xx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(src)
+	runTests(t, src+tests, sbl.Options{})
+}
+
+func TestDecompileJSExpression(t *testing.T) {
+	aliases := `$alias addedit up pipe abb say ${0+} | js function:"args.join(\" \").toUpperCase()"`
+	roundTrip(t, aliases, `
+test "expression"
+	run up "hi"
+	expect output "HI"
+end
+`)
+}
+
+func TestDecompileJSStatements(t *testing.T) {
+	aliases := `$alias addedit up js function:"let s = args.join(\" \"); s.toUpperCase()"`
+	roundTrip(t, aliases, `
+test "statements"
+	run up "hi"
+	expect output "HI"
+end
+`)
+}
+
+func TestDecompileRetrieveSet(t *testing.T) {
+	aliases := `$alias addedit cp pipe js function:"customData.get(\"k\")" | js function:"customData.set(\"x\", args.join(' '))"`
+	roundTrip(t, aliases, `
+test "retrieve and set"
+	data "k" = "v"
+	run cp
+	expect data "x" = "v"
+end
+`)
+}
+
+func TestDecompileRoundTrip(t *testing.T) {
+	src := `
+alias xx
+	${0+} -> say -> set temp "in"
+	exec "abb say one" -> set "one"
+	get "one" -> say -> set "two"
+	get "in" -> js ` + "```" + `return args.join(" ") + "!"` + "```" + `
+end
+`
+	tests := `
+test "round trip"
+	run xx "hello" "there"
+	expect output "hello there!"
+	expect data "one" = "one"
+	expect data "two" = "one"
+	expect unset "in"
+end
+`
+	ast, err := sbl.Parse("test.sbl", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := sbl.Compile(ast, sbl.Options{Entry: "xx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTests(t, src+tests, sbl.Options{})
+	roundTrip(t, res.Code(), tests)
+}
@@ -0,0 +1,285 @@
+package sbl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// How serious a Diagnostic is
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	default:
+		return "unknown"
+	}
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// A range of source text, End is the position directly after the range.
+// The zero value means the message is not about any specific text.
+type Range struct {
+	Start, End lexer.Position
+}
+
+// pointRange is an empty range at pos
+func pointRange(pos lexer.Position) Range {
+	return Range{pos, pos}
+}
+
+func (r Range) IsZero() bool {
+	return r.Start.Line == 0 && r.Start.Filename == ""
+}
+
+func (r Range) String() string {
+	return r.Start.String()
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+func (r Range) MarshalJSON() ([]byte, error) {
+	if r.IsZero() {
+		return []byte("null"), nil
+	}
+	end := r.End
+	if end.Line == 0 {
+		end = r.Start
+	}
+	return json.Marshal(struct {
+		Filename string       `json:"filename"`
+		Start    jsonPosition `json:"start"`
+		End      jsonPosition `json:"end"`
+	}{
+		r.Start.Filename,
+		jsonPosition{r.Start.Line, r.Start.Column, r.Start.Offset},
+		jsonPosition{end.Line, end.Column, end.Offset},
+	})
+}
+
+// Additional information about a diagnostic, usually about another part of the source
+type Note struct {
+	Range   Range  `json:"range"`
+	Message string `json:"message"`
+}
+
+// A message about the source, reported while parsing or compiling
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Range    Range    `json:"range"`
+	// Identifies the kind of message, eg. "unknown-block"
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Notes   []Note `json:"notes,omitempty"`
+}
+
+// String formats the diagnostic on one line, followed by a line per note
+func (d Diagnostic) String() string {
+	out := d.Severity.String() + "[" + d.Code + "]: " + d.Message
+	if !d.Range.IsZero() {
+		out = d.Range.String() + ": " + out
+	}
+	for _, n := range d.Notes {
+		if n.Range.IsZero() {
+			out += "\n\tnote: " + n.Message
+		} else {
+			out += "\n\t" + n.Range.String() + ": note: " + n.Message
+		}
+	}
+	return out
+}
+
+// A list of diagnostics, which is an error if any of them are errors
+type Diagnostics []Diagnostic
+
+// HasErrors reports whether any of the diagnostics are errors
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (ds Diagnostics) Error() string {
+	messages := []string{}
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			messages = append(messages, d.String())
+		}
+	}
+	return strings.Join(messages, "\n")
+}
+
+// WriteJSON writes the diagnostics as a JSON array
+func (ds Diagnostics) WriteJSON(w io.Writer) error {
+	if ds == nil {
+		ds = Diagnostics{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(ds)
+}
+
+// WriteText writes the diagnostics in a readable format,
+// showing the source line of each diagnostic when the file can be read
+func (ds Diagnostics) WriteText(w io.Writer) error {
+	sources := make(map[string][]string)
+	sourceLine := func(r Range) (string, bool) {
+		if r.IsZero() || r.Start.Filename == "" {
+			return "", false
+		}
+		lines, ok := sources[r.Start.Filename]
+		if !ok {
+			bytes, err := os.ReadFile(r.Start.Filename)
+			if err == nil {
+				lines = strings.Split(string(bytes), "\n")
+			}
+			sources[r.Start.Filename] = lines
+		}
+		if r.Start.Line < 1 || r.Start.Line > len(lines) {
+			return "", false
+		}
+		return lines[r.Start.Line-1], true
+	}
+	// underline the range on its first line, keeping tabs so the columns line up
+	snippet := func(r Range) string {
+		line, ok := sourceLine(r)
+		if !ok {
+			return ""
+		}
+		start := r.Start.Column - 1
+		end := len(line)
+		if r.End.Line == r.Start.Line && r.End.Column > r.Start.Column {
+			end = r.End.Column - 1
+		}
+		if start < 0 || start > len(line) || end > len(line) {
+			return ""
+		}
+		underline := ""
+		for _, c := range line[:start] {
+			if c == '\t' {
+				underline += "\t"
+			} else {
+				underline += " "
+			}
+		}
+		underline += "^"
+		if end-start > 1 {
+			underline += strings.Repeat("~", end-start-1)
+		}
+		gutter := fmt.Sprintf("%5d | ", r.Start.Line)
+		return gutter + line + "\n" + strings.Repeat(" ", len(gutter)-2) + "| " + underline + "\n"
+	}
+
+	for _, d := range ds {
+		header := d.Severity.String() + "[" + d.Code + "]: " + d.Message
+		if !d.Range.IsZero() {
+			header = d.Range.String() + ": " + header
+		}
+		if _, err := fmt.Fprintln(w, header); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, snippet(d.Range)); err != nil {
+			return err
+		}
+		for _, n := range d.Notes {
+			note := "note: " + n.Message
+			if !n.Range.IsZero() {
+				note = n.Range.String() + ": " + note
+			}
+			if _, err := fmt.Fprintln(w, note); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, snippet(n.Range)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// An error that is reported as a diagnostic
+type diagnosticError struct {
+	Diagnostic
+}
+
+func (e *diagnosticError) Error() string {
+	return e.Diagnostic.String()
+}
+
+// errorAt returns an error that is reported as a diagnostic covering r
+func errorAt(r Range, code string, format string, args ...interface{}) error {
+	return &diagnosticError{Diagnostic{
+		Severity: SeverityError,
+		Range:    r,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	}}
+}
+
+// withNotes adds notes to an error returned by errorAt
+func withNotes(err error, notes ...Note) error {
+	var derr *diagnosticError
+	if errors.As(err, &derr) {
+		derr.Notes = append(derr.Notes, notes...)
+	}
+	return err
+}
+
+// errReported is returned when the errors have already been reported as diagnostics
+var errReported = errors.New("errors were reported as diagnostics")
+
+// DiagnosticFromError converts an error returned while parsing or compiling to a Diagnostic
+func DiagnosticFromError(err error) Diagnostic {
+	var derr *diagnosticError
+	if errors.As(err, &derr) {
+		return derr.Diagnostic
+	}
+	var perr participle.Error
+	if errors.As(err, &perr) {
+		return Diagnostic{
+			Severity: SeverityError,
+			Range:    pointRange(perr.Position()),
+			Code:     "syntax",
+			Message:  perr.Message(),
+		}
+	}
+	return Diagnostic{Severity: SeverityError, Code: "error", Message: err.Error()}
+}
+
+// DiagnosticsFromError converts an error to diagnostics,
+// an error that is already Diagnostics is returned as it is
+func DiagnosticsFromError(err error) Diagnostics {
+	var diagnostics Diagnostics
+	if errors.As(err, &diagnostics) {
+		return diagnostics
+	}
+	return Diagnostics{DiagnosticFromError(err)}
+}
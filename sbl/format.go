@@ -0,0 +1,485 @@
+package sbl
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// Format parses a file (without its imports), and prints it in the canonical style,
+// keeping its comments.
+//
+// Bodies are indented with one tab, dispatch cases are at the level of "dispatch",
+// action chains are on one line joined with " -> ", pipe lists are on one line
+// like `exec "a" | "b"`, and multiline javascript is indented one level more than
+// the line it starts on. Single blank lines between actions are kept.
+func Format(filename string, src []byte) ([]byte, error) {
+	ast := &SBLFile{}
+	if err := parser.ParseBytes(filename, src, ast); err != nil {
+		return nil, err
+	}
+	f, err := newFormatter(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	f.file(ast)
+	return []byte(strings.Join(f.lines, "\n") + "\n"), nil
+}
+
+type formatter struct {
+	lines []string
+	// tokens of the source, without comments and whitespace
+	tokens   []lexer.Token
+	comments []formatComment
+	// index of the next comment to print
+	next int
+	// last line of the source that was printed
+	lastLine int
+	// a body was just opened, so no blank line is added before its first line
+	open bool
+}
+
+type formatComment struct {
+	lexer.Token
+	// the comment is after code on the same line
+	trailing bool
+}
+
+// newFormatter lexes the source again, because the parser discards the comments
+func newFormatter(filename string, src []byte) (*formatter, error) {
+	lex, err := aliasLexer.Lex(filename, bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := lexer.ConsumeAll(lex)
+	if err != nil {
+		return nil, err
+	}
+	symbols := aliasLexer.Symbols()
+	f := &formatter{}
+	for _, token := range tokens {
+		switch token.Type {
+		case symbols["Comment"]:
+			trailing := len(f.tokens) > 0 && tokenEndLine(f.tokens[len(f.tokens)-1]) == token.Pos.Line
+			f.comments = append(f.comments, formatComment{token, trailing})
+		case symbols["Whitespace"], lexer.EOF:
+		default:
+			f.tokens = append(f.tokens, token)
+		}
+	}
+	return f, nil
+}
+
+func tokenEndLine(token lexer.Token) int {
+	return token.Pos.Line + strings.Count(token.Value, "\n")
+}
+
+// nextToken returns the first token at or after offset
+func (f *formatter) nextToken(offset int) lexer.Token {
+	i := sort.Search(len(f.tokens), func(i int) bool { return f.tokens[i].Pos.Offset >= offset })
+	if i == len(f.tokens) {
+		return lexer.Token{Type: lexer.EOF, Pos: lexer.Position{Offset: math.MaxInt32}}
+	}
+	return f.tokens[i]
+}
+
+// done records that the source up to end was printed
+func (f *formatter) done(end lexer.Position) {
+	i := sort.Search(len(f.tokens), func(i int) bool { return f.tokens[i].Pos.Offset >= end.Offset })
+	if i > 0 && tokenEndLine(f.tokens[i-1]) > f.lastLine {
+		f.lastLine = tokenEndLine(f.tokens[i-1])
+	}
+}
+
+// write text to the current line, lines after the first are not indented
+func (f *formatter) write(text string) {
+	parts := strings.Split(text, "\n")
+	f.lines[len(f.lines)-1] += parts[0]
+	f.lines = append(f.lines, parts[1:]...)
+}
+
+func (f *formatter) newline(indent int) {
+	f.lines = append(f.lines, strings.Repeat("\t", indent))
+	f.open = false
+}
+
+func (f *formatter) blank() {
+	if len(f.lines) > 0 && f.lines[len(f.lines)-1] != "" {
+		f.lines = append(f.lines, "")
+	}
+}
+
+// separate adds a blank line if there is one in the source before line
+func (f *formatter) separate(line int) {
+	if f.open {
+		f.open = false
+		return
+	}
+	if f.lastLine > 0 && line > f.lastLine+1 {
+		f.blank()
+	}
+}
+
+// pending reports whether there is a comment to print before offset
+func (f *formatter) pending(offset int) bool {
+	return f.next < len(f.comments) && f.comments[f.next].Pos.Offset < offset
+}
+
+// comment prints the next comment, after the current line if it is trailing
+func (f *formatter) comment(indent int) {
+	c := f.comments[f.next]
+	f.next++
+	text := strings.TrimRight(c.Value, " \t\r")
+	if c.trailing && len(f.lines) > 0 && f.lines[len(f.lines)-1] != "" {
+		f.write(" " + text)
+	} else {
+		f.separate(c.Pos.Line)
+		f.newline(indent)
+		f.write(text)
+	}
+	f.lastLine = c.Pos.Line
+}
+
+func (f *formatter) commentsBefore(offset int, indent int) {
+	for f.pending(offset) {
+		f.comment(indent)
+	}
+}
+
+// A line of a body, like an action or a test step
+type formatItem struct {
+	pos, end lexer.Position
+	print    func()
+}
+
+// body prints the items on new lines with indent, and the comments before the token
+// after them, which is returned. Comments are indented like the closing token if they
+// are not indented more than it in the source.
+// after is where to look for the closing token if there are no items.
+func (f *formatter) body(items []formatItem, after int, indent int, closeIndent int) lexer.Token {
+	f.open = true
+	for _, item := range items {
+		f.commentsBefore(item.pos.Offset, indent)
+		f.separate(item.pos.Line)
+		f.newline(indent)
+		item.print()
+		f.done(item.end)
+		after = item.end.Offset
+	}
+	close := f.nextToken(after)
+	for f.pending(close.Pos.Offset) {
+		if f.comments[f.next].Pos.Column > close.Pos.Column {
+			f.comment(indent)
+		} else {
+			f.comment(closeIndent)
+		}
+	}
+	f.open = false
+	return close
+}
+
+func (f *formatter) actions(actions []*AliasAction, after int, indent int) lexer.Token {
+	items := make([]formatItem, len(actions))
+	for i, action := range actions {
+		action := action
+		items[i] = formatItem{action.Pos, action.EndPos, func() { f.action(action, indent+1) }}
+	}
+	return f.body(items, after, indent+1, indent)
+}
+
+// endBody prints the actions of a body, and the "end" after them
+func (f *formatter) endBody(actions []*AliasAction, after int, indent int) {
+	f.actions(actions, after, indent)
+	f.newline(indent)
+	f.write("end")
+}
+
+func (f *formatter) file(ast *SBLFile) {
+	for i := range ast.Declarations {
+		d := &ast.Declarations[i]
+		// aliases, blocks and tests are always separated by a blank line
+		if i > 0 && (ast.Declarations[i-1].hasBody() || d.hasBody()) {
+			for f.pending(d.Pos.Offset) && f.comments[f.next].trailing {
+				f.comment(0)
+			}
+			f.blank()
+		}
+		f.commentsBefore(d.Pos.Offset, 0)
+		f.separate(d.Pos.Line)
+		f.newline(0)
+		f.declaration(d)
+		f.done(d.EndPos)
+	}
+	f.commentsBefore(math.MaxInt32, 0)
+	if len(f.lines) == 0 {
+		f.lines = []string{""}
+	}
+}
+
+func (d *Declaration) hasBody() bool {
+	return d.Alias != nil || d.Block != nil || d.Test != nil
+}
+
+func (f *formatter) declaration(d *Declaration) {
+	switch {
+	case d.Entrypoint != nil:
+		f.write("entry " + *d.Entrypoint)
+	case d.Import != nil:
+		f.write("import " + quote(*d.Import))
+	case d.Alias != nil:
+		a := d.Alias
+		f.write("alias " + a.Name)
+		if a.Keyprefix != nil {
+			f.write(" prefixed " + quote(*a.Keyprefix))
+		}
+		if len(a.Options) > 0 {
+			f.write(" with " + formatOptions(a.Options))
+		}
+		f.endBody(a.Body.Actions, a.Body.Pos.Offset, 0)
+	case d.Block != nil:
+		f.write("block " + d.Block.Name)
+		f.endBody(d.Block.Body.Actions, d.Block.Body.Pos.Offset, 0)
+	case d.Test != nil:
+		f.test(d.Test)
+	}
+}
+
+func formatOptions(options []*Option) string {
+	parts := make([]string, len(options))
+	for i, o := range options {
+		parts[i] = o.Name + "=" + o.Value
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *formatter) action(a *AliasAction, indent int) {
+	switch {
+	case a.ExecuteAction != nil:
+		ea := a.ExecuteAction
+		if ea.RetrieveAction != nil {
+			f.write(formatRetrieve(ea.RetrieveAction) + " -> ")
+		}
+		f.simple(ea.SimpleAction, indent)
+		f.continued(ea.ContinueAction, indent)
+	case a.GetCompiledAction != nil:
+		gc := a.GetCompiledAction
+		f.write("get compiled")
+		if gc.UseBlock != nil {
+			f.write(" use " + gc.UseBlock.BlockName)
+		} else {
+			f.endBody(gc.CompilationRoot.Actions, gc.CompilationRoot.Pos.Offset, indent)
+		}
+		f.continued(gc.ContinueAction, indent)
+	case a.IfAction != nil:
+		ia := a.IfAction
+		if ia.RetrieveAction != nil {
+			f.write(formatRetrieve(ia.RetrieveAction) + " -> ")
+		}
+		f.write("if " + formatJS(ia.Condition.RawString, indent))
+		close := f.actions(ia.Then, ia.Condition.EndPos.Offset, indent)
+		if close.Value == "else" {
+			f.newline(indent)
+			f.write("else")
+			f.actions(ia.Else, close.Pos.Offset+len("else"), indent)
+		}
+		f.newline(indent)
+		f.write("end")
+		f.continued(ia.ContinueAction, indent)
+	case a.DispatchAction != nil:
+		da := a.DispatchAction
+		f.write("dispatch " + formatRetrieve(da.Subject))
+		items := make([]formatItem, len(da.Cases))
+		for i, dc := range da.Cases {
+			dc := dc
+			items[i] = formatItem{dc.Pos, dc.EndPos, func() { f.dispatchCase(dc, indent) }}
+		}
+		// cases are at the same level as dispatch
+		f.body(items, da.Subject.EndPos.Offset, indent, indent)
+		f.newline(indent)
+		f.write("end")
+		f.continued(da.ContinueAction, indent)
+	case a.UseBlock != nil:
+		f.write("use " + a.UseBlock.BlockName)
+	case a.OptionsAction != nil:
+		oa := a.OptionsAction
+		f.write("options " + formatOptions(oa.Options))
+		f.endBody(oa.Actions, oa.Options[len(oa.Options)-1].EndPos.Offset, indent)
+	}
+}
+
+func (f *formatter) dispatchCase(dc *DispatchCase, indent int) {
+	switch {
+	case dc.Empty:
+		f.write("empty")
+	case dc.Default:
+		f.write("default")
+	default:
+		values := make([]string, len(dc.Values))
+		for i, value := range dc.Values {
+			values[i] = quote(value)
+		}
+		f.write("case " + strings.Join(values, ", "))
+	}
+	f.actions(dc.Actions, dc.EndPos.Offset, indent)
+}
+
+func (f *formatter) simple(ea *ExecuteActionSimple, indent int) {
+	switch {
+	case ea.JSExec != nil:
+		js := ea.JSExec
+		f.write("js ")
+		if js.ImportedGist != nil {
+			f.write("import " + quote(*js.ImportedGist) + " ")
+		}
+		if len(js.InjectedGists) > 0 {
+			gists := make([]string, len(js.InjectedGists))
+			for i, gist := range js.InjectedGists {
+				gists[i] = quote(gist)
+			}
+			f.write("inject " + strings.Join(gists, ", ") + " ")
+		}
+		f.write(formatJS(js.ExecString.RawString, indent))
+	case ea.PipeCommandLiterals != nil:
+		commands := make([]string, len(ea.PipeCommandLiterals))
+		for i, command := range ea.PipeCommandLiterals {
+			commands[i] = quote(command)
+		}
+		f.write("exec " + strings.Join(commands, " | "))
+	case ea.UseSayLiteral:
+		f.write("say")
+		if ea.SayLiteral != nil {
+			f.write(" " + quote(*ea.SayLiteral))
+		}
+	case ea.CallAlias != nil:
+		f.write("call ")
+		if ea.CallAlias.User != nil {
+			f.write(*ea.CallAlias.User + " ")
+		}
+		f.write(ea.CallAlias.AliasName)
+	}
+}
+
+func (f *formatter) continued(ca *ContinuedAction, indent int) {
+	for ; ca != nil; ca = ca.SecondContinue {
+		f.write(" -> ")
+		if ca.StoreKey == nil {
+			f.simple(ca.NextAction, indent)
+			continue
+		}
+		f.write("set ")
+		if ca.StoreKeyTemp {
+			f.write("temp ")
+		}
+		if ca.StoreKeyLocal {
+			f.write("local ")
+		}
+		f.write(quote(*ca.StoreKey))
+	}
+}
+
+func formatRetrieve(ra *RetrieveAction) string {
+	if ra.RetrieveArgs != nil {
+		return *ra.RetrieveArgs
+	}
+	if ra.LocalRetrieveKey {
+		return "get local " + quote(*ra.RetrieveKey)
+	}
+	return "get " + quote(*ra.RetrieveKey)
+}
+
+// formatJS quotes javascript with ```, multiline javascript is indented one level
+// more than indent, with the closing ``` on its own line.
+// Javascript with backticks is kept as it is, because they may be template literals,
+// where whitespace matters.
+func formatJS(code string, indent int) string {
+	if !strings.Contains(code, "\n") || strings.Contains(code, "`") {
+		return "```" + code + "```"
+	}
+	lines := strings.Split(code, "\n")
+	first, rest := strings.TrimRight(lines[0], " \t"), lines[1:]
+	if strings.TrimSpace(rest[len(rest)-1]) == "" {
+		rest = rest[:len(rest)-1]
+	}
+	prefix := commonIndent(rest)
+	var out strings.Builder
+	out.WriteString("```" + first)
+	for _, line := range rest {
+		line = strings.TrimRight(line, " \t\r")
+		out.WriteString("\n")
+		if line != "" {
+			out.WriteString(strings.Repeat("\t", indent+1) + strings.TrimPrefix(line, prefix))
+		}
+	}
+	out.WriteString("\n" + strings.Repeat("\t", indent) + "```")
+	return out.String()
+}
+
+// commonIndent returns the whitespace at the start of every line that is not blank
+func commonIndent(lines []string) string {
+	prefix := ""
+	found := false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if !found {
+			prefix, found = indent, true
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+func (f *formatter) test(t *Test) {
+	f.write("test " + quote(t.Name))
+	items := make([]formatItem, len(t.Steps))
+	for i, step := range t.Steps {
+		step := step
+		items[i] = formatItem{step.Pos, step.EndPos, func() { f.write(formatTestStep(step)) }}
+	}
+	name := f.nextToken(t.Pos.Offset + len("test"))
+	f.body(items, name.Pos.Offset+len(name.Value), 1, 0)
+	f.newline(0)
+	f.write("end")
+}
+
+func formatTestStep(step *TestStep) string {
+	switch {
+	case step.Run != nil:
+		run := "run " + step.Run.Alias
+		for _, arg := range step.Run.Args {
+			run += " " + quote(arg)
+		}
+		return run
+	case step.Executor != nil:
+		return "as " + quote(*step.Executor)
+	case step.Channel != nil:
+		return "in " + quote(*step.Channel)
+	case step.Data != nil:
+		return "data " + formatTestData(step.Data)
+	case step.Stub != nil:
+		return "stub " + quote(step.Stub.Command) + " " + quote(step.Stub.Output)
+	}
+	expect := step.Expect
+	switch {
+	case expect.Output != nil:
+		return "expect output " + quote(*expect.Output)
+	case expect.Data != nil:
+		return "expect data " + formatTestData(expect.Data)
+	case expect.Unset != nil:
+		return "expect unset " + quote(*expect.Unset)
+	default:
+		return "expect error " + quote(*expect.Error)
+	}
+}
+
+func formatTestData(data *TestData) string {
+	return quote(data.Key) + " = " + quote(data.Value)
+}
@@ -0,0 +1,385 @@
+package sbl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// https://github.com/Supinic/supibot-package-manager/blob/master/commands/pastebin/index.js#L14
+var allowedGistTypes = []string{"text/plain", "application/javascript"}
+
+// supibot only imports gists with one eligible file
+var errTooManyFiles = errors.New("too many eligible files found in this Gist")
+
+// ErrOffline is the error for a gist that is not cached in offline mode
+var ErrOffline = errors.New("not cached, and gists can't be fetched offline")
+
+// LockFileName is the name of the lock file, which records the revision and content hash
+// of every injected gist, so that every build uses the same content.
+// By default the lock file of a compiled file is next to it.
+const LockFileName = "sbl.lock"
+
+// guards the gist cache and the lock file
+var gistMu sync.Mutex
+
+type gistLock struct {
+	Gists map[string]LockedGist `json:"gists"`
+}
+
+type LockedGist struct {
+	// SHA of the revision of the gist
+	Revision string `json:"revision"`
+	// sha256 of the content, as "sha256:<hex>"
+	Hash string `json:"hash"`
+}
+
+// GistUpdate is a gist that was updated by UpdateGists, Old is empty if it was not in the lock
+type GistUpdate struct {
+	ID       string
+	Old, New LockedGist
+}
+
+// gistStore fetches, caches and locks gists, with the settings of Options
+type gistStore struct {
+	fetcher  Fetcher
+	cacheDir string
+	offline  bool
+	lockFile string
+	// add the gists that are not in the lock file to it
+	writeLock bool
+}
+
+// gists returns the gistStore for the options, with the GitHub API if Fetcher is nil,
+// the default cache directory if CacheDir is empty, and LockFileName if LockFile is empty
+func (o Options) gists() *gistStore {
+	g := &gistStore{fetcher: o.Fetcher, cacheDir: o.CacheDir, offline: o.Offline, lockFile: o.LockFile, writeLock: o.WriteLock}
+	if g.fetcher == nil {
+		g.fetcher = &GitHubFetcher{API: "https://api.github.com"}
+	}
+	if g.cacheDir == "" {
+		g.cacheDir = DefaultCacheDir()
+	}
+	if g.lockFile == "" {
+		g.lockFile = LockFileName
+	}
+	return g
+}
+
+// DefaultCacheDir returns the directory gists are cached in when Options.CacheDir is empty,
+// "supilang/gists" in the user's cache directory
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".gistcache"
+	}
+	return filepath.Join(dir, "supilang", "gists")
+}
+
+// CachedGist returns the content of a gist from the cache of opts
+func CachedGist(opts Options, id string) (string, error) {
+	if err := validateGistRef(id); err != nil {
+		return "", err
+	}
+	content, err := opts.gists().readCache(id)
+	if err != nil {
+		return "", fmt.Errorf("gist %s is not cached: %w", id, err)
+	}
+	return content, nil
+}
+
+// CachedGists returns the ids of the gists in the gist cache of opts
+func CachedGists(opts Options) ([]string, error) {
+	entries, err := os.ReadDir(opts.gists().cacheDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+func validateGistID(id string) error {
+	if match, err := regexp.MatchString("^[0-9a-fA-F]*$", id); !match || err != nil {
+		if err != nil {
+			return err
+		}
+		return errors.New("gist ids can only contain hexadecimal characters (0123456789abcdefABCDEF)")
+	}
+	if id == "" {
+		return errors.New("a gist id cannot be the empty string")
+	}
+	return nil
+}
+
+// splitGistRef splits "id#file" into the id of the gist and the name of the file,
+// which is empty when the gist has one file that is used
+func splitGistRef(ref string) (id, file string) {
+	if i := strings.Index(ref, "#"); i != -1 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+func validateGistRef(ref string) error {
+	id, file := splitGistRef(ref)
+	if err := validateGistID(id); err != nil {
+		return err
+	}
+	if strings.Contains(ref, "#") && file == "" {
+		return errors.New("the file name after \"#\" cannot be empty")
+	}
+	if strings.ContainsAny(file, `/\`) {
+		return errors.New("file names in gists cannot contain slashes")
+	}
+	return nil
+}
+
+// content returns the content of a gist ("id", or "id#file" to choose a file),
+// at the revision in the lock file, and warnings from fetching it.
+// Gists that are not in the lock file are fetched at their latest revision,
+// and added to it if writeLock is true.
+func (g *gistStore) content(id string) (string, []string, error) {
+	if err := validateGistRef(id); err != nil {
+		return "", nil, err
+	}
+	gistMu.Lock()
+	defer gistMu.Unlock()
+
+	lock, err := readLock(g.lockFile)
+	if err != nil {
+		return "", nil, err
+	}
+	locked, ok := lock.Gists[id]
+	if !ok && (g.offline || !g.writeLock) {
+		// without a revision to lock, the cached content is used as it is
+		content, err := g.readCache(id)
+		if err == nil {
+			return content, nil, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", nil, err
+		} else if g.offline {
+			return "", nil, ErrOffline
+		}
+	}
+	if !ok {
+		content, revision, warnings, err := g.fetch(id, "")
+		if err != nil {
+			return "", warnings, err
+		}
+		if err := g.writeCache(id, content); err != nil {
+			return "", warnings, err
+		}
+		if !g.writeLock {
+			return content, warnings, nil
+		}
+		lock.Gists[id] = LockedGist{Revision: revision, Hash: contentHash(content)}
+		return content, warnings, lock.write(g.lockFile)
+	}
+
+	content, err := g.readCache(id)
+	if err == nil && contentHash(content) == locked.Hash {
+		return content, nil, nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", nil, err
+	} else if err == nil && g.offline {
+		return "", nil, fmt.Errorf("the cached content is not the revision in %s, which can't be fetched offline", g.lockFile)
+	}
+	// the gist is not cached, or the cache has another revision, like the one locked by another project
+	content, _, warnings, err := g.fetch(id, locked.Revision)
+	if err != nil {
+		return "", warnings, err
+	}
+	if contentHash(content) != locked.Hash {
+		update := "supilang update " + id
+		if g.lockFile != LockFileName {
+			update = fmt.Sprintf("supilang update -lock %s %s", g.lockFile, id)
+		}
+		return "", warnings, fmt.Errorf("revision %s does not match the hash in %s, the lock can be updated with %q", locked.Revision, g.lockFile, update)
+	}
+	return content, warnings, g.writeCache(id, content)
+}
+
+// PrefetchGist puts a gist in the cache, at the revision in the lock file like when it is injected,
+// adding it to the lock file if it is not in it. The warnings from fetching it are returned.
+func PrefetchGist(opts Options, id string) ([]string, error) {
+	g := opts.gists()
+	g.writeLock = true
+	_, warnings, err := g.content(id)
+	return warnings, err
+}
+
+// PruneCache removes the gists that are not in the lock file from the cache, or every gist with all.
+// The ids of the removed gists are returned.
+func PruneCache(opts Options, all bool) ([]string, error) {
+	gistMu.Lock()
+	defer gistMu.Unlock()
+
+	g := opts.gists()
+	lock, err := readLock(g.lockFile)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := CachedGists(opts)
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, id := range ids {
+		if _, ok := lock.Gists[id]; ok && !all {
+			continue
+		}
+		if err := os.Remove(filepath.Join(g.cacheDir, id)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, id)
+	}
+	return removed, nil
+}
+
+// UpdateGists fetches the latest revision of the gists, and updates the cache and the lock file.
+// Without ids, every gist in the lock file is updated.
+// The gists that changed are returned, with the warnings from fetching them.
+func UpdateGists(opts Options, ids []string) (updates []GistUpdate, warnings []string, err error) {
+	gistMu.Lock()
+	defer gistMu.Unlock()
+
+	g := opts.gists()
+	lock, err := readLock(g.lockFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		for id := range lock.Gists {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+	updates = []GistUpdate{}
+	for _, id := range ids {
+		if err := validateGistRef(id); err != nil {
+			return updates, warnings, fmt.Errorf("gist %s: %w", id, err)
+		}
+		content, revision, fetchWarnings, err := g.fetch(id, "")
+		for _, w := range fetchWarnings {
+			warnings = append(warnings, fmt.Sprintf("gist %s: %s", id, w))
+		}
+		if err != nil {
+			return updates, warnings, fmt.Errorf("gist %s: %w", id, err)
+		}
+		if err := g.writeCache(id, content); err != nil {
+			return updates, warnings, err
+		}
+		locked := LockedGist{Revision: revision, Hash: contentHash(content)}
+		if old := lock.Gists[id]; old != locked {
+			updates = append(updates, GistUpdate{id, old, locked})
+			lock.Gists[id] = locked
+		}
+	}
+	return updates, warnings, lock.write(g.lockFile)
+}
+
+// fetch returns the content of a file of a gist ("id#file"), or its only eligible file,
+// and the revision it is from. If revision is empty, the latest revision is fetched.
+func (g *gistStore) fetch(ref, revision string) (content string, fetched string, warnings []string, err error) {
+	if g.offline {
+		return "", "", nil, ErrOffline
+	}
+	id, name := splitGistRef(ref)
+	gist, err := g.fetcher.FetchGist(id, revision)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(gist.Files) == 0 {
+		return "", "", gist.Warnings, errors.New("there are no files in this Gist")
+	}
+	if name != "" {
+		file, ok := gist.Files[name]
+		if !ok {
+			names := []string{}
+			for n := range gist.Files {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return "", "", gist.Warnings, fmt.Errorf("there is no file named %s in this Gist, it has %s", name, strings.Join(names, ", "))
+		}
+		return file.Content, gist.Revision, gist.Warnings, nil
+	}
+	eligibleFiles := []GistFile{}
+	for _, v := range gist.Files {
+		for _, allowedType := range allowedGistTypes {
+			if v.Type == allowedType {
+				eligibleFiles = append(eligibleFiles, v)
+				break
+			}
+		}
+	}
+	if len(eligibleFiles) == 0 {
+		return "", "", gist.Warnings, errors.New("no eligible files found in this Gist")
+	}
+	if len(eligibleFiles) > 1 {
+		return "", "", gist.Warnings, errTooManyFiles
+	}
+	return eligibleFiles[0].Content, gist.Revision, gist.Warnings, nil
+}
+
+func (g *gistStore) readCache(id string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(g.cacheDir, id))
+	return string(content), err
+}
+
+func (g *gistStore) writeCache(id, content string) error {
+	err := os.MkdirAll(g.cacheDir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(g.cacheDir, id), []byte(content), 0644)
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// readLock reads a lock file, which is empty if it doesn't exist
+func readLock(path string) (*gistLock, error) {
+	lock := &gistLock{}
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		lock.Gists = make(map[string]LockedGist)
+		return lock, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, lock); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if lock.Gists == nil {
+		lock.Gists = make(map[string]LockedGist)
+	}
+	return lock, nil
+}
+
+func (l *gistLock) write(path string) error {
+	// json sorts the keys of maps, so the file only changes when a gist does
+	bytes, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bytes, '\n'), 0644)
+}
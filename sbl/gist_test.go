@@ -0,0 +1,194 @@
+package sbl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a response of the fake GitHub API
+type apiResponse struct {
+	status int
+	body   string
+	// X-RateLimit-Remaining, not sent if it is empty
+	remaining string
+}
+
+// fakeAPI serves gists like the GitHub API
+type fakeAPI struct {
+	url string
+	// responses by path, like "/gists/abc" or "/gists/abc/r1"
+	responses map[string]apiResponse
+	// paths of the requests so far
+	requests []string
+	// path of the lock file and the cache, in a temporary directory
+	lockFile, cacheDir string
+	// fail instead of fetching gists that are not cached
+	offline bool
+}
+
+// gistServer starts a fakeAPI, that gists are fetched from with the options of it.
+// The cache and the lock file are in a temporary directory.
+func gistServer(t *testing.T, responses map[string]apiResponse) *fakeAPI {
+	t.Helper()
+	api := &fakeAPI{responses: responses}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.requests = append(api.requests, r.URL.Path)
+		resp, ok := api.responses[r.URL.Path]
+		if !ok {
+			resp = apiResponse{status: http.StatusNotFound, body: `{"message":"Not Found"}`}
+		}
+		if resp.remaining != "" {
+			w.Header().Set("X-RateLimit-Remaining", resp.remaining)
+			w.Header().Set("X-RateLimit-Reset", "1900000000")
+		}
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+	}))
+	api.url = srv.URL
+	dir := t.TempDir()
+	api.lockFile = filepath.Join(dir, LockFileName)
+	api.cacheDir = filepath.Join(dir, "cache")
+	t.Cleanup(srv.Close)
+	return api
+}
+
+// options returns the Options that fetch gists from the fakeAPI and use its cache, with a lock file
+func (api *fakeAPI) options(lockFile string) Options {
+	return Options{Fetcher: &GitHubFetcher{API: api.url}, CacheDir: api.cacheDir, Offline: api.offline, LockFile: lockFile}
+}
+
+// store returns the gistStore of the options, which adds gists to the lock file with writeLock
+func (api *fakeAPI) store(lockFile string, writeLock bool) *gistStore {
+	opts := api.options(lockFile)
+	opts.WriteLock = writeLock
+	return opts.gists()
+}
+
+// gistJSON is the API response for a revision of a gist with javascript files, by name
+func gistJSON(revision string, files map[string]string) apiResponse {
+	gistFiles := make(map[string]githubGistFile)
+	for name, content := range files {
+		gistFiles[name] = githubGistFile{Type: "application/javascript", Content: content}
+	}
+	return gistFilesJSON(revision, gistFiles)
+}
+
+// gistFilesJSON is the API response for a revision of a gist
+func gistFilesJSON(revision string, files map[string]githubGistFile) apiResponse {
+	resp := &githubGistAPIResp{Files: files}
+	resp.History = append(resp.History, struct {
+		Version string `json:"version"`
+	}{revision})
+	bytes, _ := json.Marshal(resp)
+	return apiResponse{status: http.StatusOK, body: string(bytes)}
+}
+
+// revisions r1 and r2 of gist abc, r2 is the latest
+func twoRevisions() map[string]apiResponse {
+	return map[string]apiResponse{
+		"/gists/abc":    gistJSON("r2", map[string]string{"a.js": "second"}),
+		"/gists/abc/r1": gistJSON("r1", map[string]string{"a.js": "first"}),
+		"/gists/abc/r2": gistJSON("r2", map[string]string{"a.js": "second"}),
+	}
+}
+
+func TestGistLock(t *testing.T) {
+	api := gistServer(t, twoRevisions())
+	lockFile := api.lockFile
+
+	content, _, err := api.store(lockFile, false).content("abc")
+	if err != nil || content != "second" {
+		t.Fatalf("got %q, %v, want the latest revision", content, err)
+	}
+	if _, err := os.Stat(lockFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the lock file was written without writeLock: %v", err)
+	}
+
+	if _, _, err := api.store(lockFile, true).content("abc"); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := readLock(lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LockedGist{Revision: "r2", Hash: contentHash("second")}
+	if lock.Gists["abc"] != want {
+		t.Errorf("locked %+v, want %+v", lock.Gists["abc"], want)
+	}
+}
+
+func TestGistCachedWithoutLock(t *testing.T) {
+	api := gistServer(t, twoRevisions())
+	for i := 0; i < 3; i++ {
+		content, _, err := api.store(api.lockFile, false).content("abc")
+		if err != nil || content != "second" {
+			t.Fatalf("got %q, %v, want the latest revision", content, err)
+		}
+	}
+	if len(api.requests) != 1 {
+		t.Errorf("requested %v, want only one request for a cached gist", api.requests)
+	}
+}
+
+func TestGistLockedRevision(t *testing.T) {
+	api := gistServer(t, twoRevisions())
+	lockFile := api.lockFile
+	lock := &gistLock{Gists: map[string]LockedGist{"abc": {Revision: "r1", Hash: contentHash("first")}}}
+	if err := lock.write(lockFile); err != nil {
+		t.Fatal(err)
+	}
+	content, _, err := api.store(lockFile, true).content("abc")
+	if err != nil || content != "first" {
+		t.Fatalf("got %q, %v, want the locked revision", content, err)
+	}
+	if len(api.requests) != 1 || api.requests[0] != "/gists/abc/r1" {
+		t.Errorf("requested %v, want only the locked revision", api.requests)
+	}
+	if cached, err := api.store(lockFile, false).readCache("abc"); err != nil || cached != "first" {
+		t.Errorf("cached %q, %v, want the locked revision", cached, err)
+	}
+
+	// the locked revision changed on the server
+	lock.Gists["abc"] = LockedGist{Revision: "r2", Hash: contentHash("first")}
+	if err := lock.write(lockFile); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(api.cacheDir, "abc"))
+	if _, _, err := api.store(lockFile, true).content("abc"); err == nil {
+		t.Error("a revision that doesn't match the hash was used")
+	}
+}
+
+func TestUpdateGists(t *testing.T) {
+	api := gistServer(t, twoRevisions())
+	lockFile := api.lockFile
+	lock := &gistLock{Gists: map[string]LockedGist{"abc": {Revision: "r1", Hash: contentHash("first")}}}
+	if err := lock.write(lockFile); err != nil {
+		t.Fatal(err)
+	}
+	updates, _, err := UpdateGists(api.options(lockFile), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newGist := LockedGist{Revision: "r2", Hash: contentHash("second")}
+	if len(updates) != 1 || updates[0].ID != "abc" || updates[0].Old.Revision != "r1" || updates[0].New != newGist {
+		t.Errorf("got updates %+v", updates)
+	}
+	lock, err = readLock(lockFile)
+	if err != nil || lock.Gists["abc"] != newGist {
+		t.Errorf("locked %+v, %v, want %+v", lock.Gists["abc"], err, newGist)
+	}
+	if content, _, err := api.store(lockFile, true).content("abc"); err != nil || content != "second" {
+		t.Errorf("got %q, %v after updating", content, err)
+	}
+
+	updates, _, err = UpdateGists(api.options(lockFile), nil)
+	if err != nil || len(updates) != 0 {
+		t.Errorf("got updates %+v, %v, want none", updates, err)
+	}
+}
@@ -0,0 +1,57 @@
+package sbl
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGistCacheOtherProject(t *testing.T) {
+	api := gistServer(t, twoRevisions())
+	// another project locks the latest revision, which replaces the cached one
+	other := filepath.Join(t.TempDir(), LockFileName)
+	lock := &gistLock{Gists: map[string]LockedGist{"abc": {Revision: "r1", Hash: contentHash("first")}}}
+	if err := lock.write(api.lockFile); err != nil {
+		t.Fatal(err)
+	}
+	if content, _, err := api.store(api.lockFile, true).content("abc"); err != nil || content != "first" {
+		t.Fatalf("got %q, %v, want the locked revision", content, err)
+	}
+	if content, _, err := api.store(other, true).content("abc"); err != nil || content != "second" {
+		t.Fatalf("got %q, %v, want the latest revision for the other project", content, err)
+	}
+
+	content, _, err := api.store(api.lockFile, true).content("abc")
+	if err != nil || content != "first" {
+		t.Errorf("got %q, %v, want the locked revision to be fetched again", content, err)
+	}
+	if last := api.requests[len(api.requests)-1]; last != "/gists/abc/r1" {
+		t.Errorf("last requested %s, want the locked revision", last)
+	}
+
+	api.store(api.lockFile, false).writeCache("abc", "second")
+	api.offline = true
+	if _, _, err := api.store(api.lockFile, true).content("abc"); err == nil || !strings.Contains(err.Error(), "can't be fetched offline") {
+		t.Errorf("got %v offline, want an error for the other revision", err)
+	}
+}
+
+func TestGistOffline(t *testing.T) {
+	api := gistServer(t, twoRevisions())
+	lockFile := api.lockFile
+	api.offline = true
+	if _, _, err := api.store(lockFile, true).content("abc"); !errors.Is(err, ErrOffline) {
+		t.Errorf("got %v, want ErrOffline", err)
+	}
+	// cached gists can be used without being in the lock file
+	if err := api.store(lockFile, false).writeCache("abc", "cached"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _, err := api.store(lockFile, true).content("abc"); err != nil || content != "cached" {
+		t.Errorf("got %q, %v, want the cached content", content, err)
+	}
+	if len(api.requests) != 0 {
+		t.Errorf("requested %v offline", api.requests)
+	}
+}
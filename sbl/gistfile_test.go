@@ -0,0 +1,45 @@
+package sbl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGistFile(t *testing.T) {
+	api := gistServer(t, map[string]apiResponse{
+		"/gists/abc": gistJSON("r1", map[string]string{"a.js": "a", "b.js": "b"}),
+	})
+	content, _, err := api.store(api.lockFile, true).content("abc#b.js")
+	if err != nil || content != "b" {
+		t.Errorf("got %q, %v, want the chosen file", content, err)
+	}
+	lock, err := readLock(api.lockFile)
+	if err != nil || lock.Gists["abc#b.js"].Revision != "r1" {
+		t.Errorf("the chosen file was not locked: %+v, %v", lock, err)
+	}
+	if _, _, err := api.store(api.lockFile, true).content("abc"); !errors.Is(err, errTooManyFiles) {
+		t.Errorf("got %v, want errTooManyFiles", err)
+	}
+	_, _, err = api.store(api.lockFile, true).content("abc#c.js")
+	if err == nil || !strings.Contains(err.Error(), "it has a.js, b.js") {
+		t.Errorf("got %v, want an error listing the files", err)
+	}
+	if _, _, err := api.store(api.lockFile, true).content("abc#"); err == nil {
+		t.Error("an empty file name was accepted")
+	}
+}
+
+func TestImportGistTooManyFiles(t *testing.T) {
+	api := gistServer(t, map[string]apiResponse{
+		"/gists/abc": gistJSON("r1", map[string]string{"a.js": "a", "b.js": "b"}),
+	})
+	ast, err := Parse("test.sbl", []byte("alias xx\n\tjs import \"abc\" ```return 1```\nend\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Compile(ast, api.options(api.lockFile))
+	if err == nil || !strings.Contains(err.Error(), "supibot can only import a gist with one") {
+		t.Errorf("got %v, want an error for importing a gist with several files", err)
+	}
+}
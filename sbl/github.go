@@ -0,0 +1,191 @@
+package sbl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Fetcher fetches gists for the compiler, Options.Fetcher can be set to fetch them from somewhere else
+type Fetcher interface {
+	// FetchGist fetches a revision of a gist, or its latest revision if revision is empty
+	FetchGist(id, revision string) (*Gist, error)
+}
+
+// Gist is a revision of a gist
+type Gist struct {
+	Revision string
+	// files by name
+	Files map[string]GistFile
+	// problems that did not stop the gist from being fetched, like a rate limit that is almost used up
+	Warnings []string
+}
+
+type GistFile struct {
+	Type    string
+	Content string
+}
+
+// GitHubFetcher fetches gists with the GitHub API
+type GitHubFetcher struct {
+	// base URL of the API, like https://api.github.com, or https://github.example.com/api/v3 for GitHub Enterprise
+	API string
+	// sent in the Authorization header if it is not empty, authenticated requests have a higher rate limit
+	Token string
+	// http.DefaultClient is used if it is nil
+	Client *http.Client
+}
+
+// warn about the rate limit when there are fewer requests left than this
+const rateLimitWarning = 10
+
+type githubGistAPIResp struct {
+	Files     map[string]githubGistFile `json:"files"`
+	Truncated bool                      `json:"truncated"`
+	Message   string                    `json:"message"`
+	History   []struct {
+		Version string `json:"version"`
+	} `json:"history"`
+}
+
+type githubGistFile struct {
+	Type      string `json:"type"`
+	Truncated bool   `json:"truncated"`
+	Content   string `json:"content"`
+	RawURL    string `json:"raw_url"`
+}
+
+func (f *GitHubFetcher) FetchGist(id, revision string) (*Gist, error) {
+	url := f.API + "/gists/" + id
+	if revision != "" {
+		url += "/" + revision
+	}
+	resp, bytes, err := f.get(url)
+	if err != nil {
+		return nil, err
+	}
+	gist := &Gist{Revision: revision, Files: make(map[string]GistFile)}
+	if warning := f.rateLimitWarning(resp.Header); warning != "" {
+		gist.Warnings = append(gist.Warnings, warning)
+	}
+	respJson := &githubGistAPIResp{}
+	err = json.Unmarshal(bytes, respJson)
+	if err != nil {
+		if resp.StatusCode != 200 {
+			return nil, f.statusError(resp, "")
+		}
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, f.statusError(resp, respJson.Message)
+	}
+	if revision == "" {
+		if len(respJson.History) == 0 {
+			return nil, errors.New("the gist has no revisions")
+		}
+		gist.Revision = respJson.History[0].Version
+	}
+	if respJson.Truncated {
+		gist.Warnings = append(gist.Warnings, "the gist has too many files for the API, some of them are missing")
+	}
+	for name, file := range respJson.Files {
+		content := file.Content
+		// the API only includes the first megabyte of a file, the rest is at raw_url
+		if file.Truncated {
+			content, err = f.raw(file.RawURL)
+			if err != nil {
+				return nil, fmt.Errorf("file %s is truncated, and could not be fetched from raw_url: %w", name, err)
+			}
+		}
+		gist.Files[name] = GistFile{Type: file.Type, Content: content}
+	}
+	return gist, nil
+}
+
+// raw fetches the full content of a truncated file
+func (f *GitHubFetcher) raw(url string) (string, error) {
+	if url == "" {
+		return "", errors.New("the file has no raw_url")
+	}
+	resp, bytes, err := f.get(url)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", f.statusError(resp, "")
+	}
+	return string(bytes), nil
+}
+
+func (f *GitHubFetcher) get(url string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if f.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.Token)
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	bytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, bytes, nil
+}
+
+// statusError is the error for a response that is not 200 OK, explaining rate limits
+func (f *GitHubFetcher) statusError(resp *http.Response, message string) error {
+	text := resp.Status
+	if message != "" && message != http.StatusText(resp.StatusCode) {
+		text += ": " + message
+	}
+	remaining, reset, ok := rateLimit(resp.Header)
+	if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) && ok && remaining == 0 {
+		text = fmt.Sprintf("the GitHub API rate limit is used up until %s", reset.Format("15:04:05"))
+		if f.Token == "" {
+			text += ", a token ($GITHUB_TOKEN) has a higher limit"
+		}
+	}
+	return errors.New(text)
+}
+
+func (f *GitHubFetcher) rateLimitWarning(header http.Header) string {
+	remaining, reset, ok := rateLimit(header)
+	if !ok || remaining == 0 || remaining >= rateLimitWarning {
+		return ""
+	}
+	warning := fmt.Sprintf("only %d GitHub API requests are left until %s", remaining, reset.Format("15:04:05"))
+	if f.Token == "" {
+		warning += ", a token ($GITHUB_TOKEN) has a higher limit"
+	}
+	return warning
+}
+
+// rateLimit returns the requests that are left, and when the limit resets, from the headers of a response
+func rateLimit(header http.Header) (remaining int, reset time.Time, ok bool) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return remaining, time.Unix(seconds, 0), true
+}
@@ -0,0 +1,48 @@
+package sbl
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGistRawURL(t *testing.T) {
+	api := gistServer(t, map[string]apiResponse{
+		"/raw/big.js": {status: http.StatusOK, body: "the first megabyte, and the rest"},
+	})
+	api.responses["/gists/abc"] = gistFilesJSON("r1", map[string]githubGistFile{
+		"big.js": {Type: "application/javascript", Content: "the first megabyte", Truncated: true, RawURL: api.url + "/raw/big.js"},
+	})
+	content, _, err := api.store(api.lockFile, true).content("abc")
+	if err != nil || content != "the first megabyte, and the rest" {
+		t.Errorf("got %q, %v, want the content from raw_url", content, err)
+	}
+
+	api.responses["/gists/def"] = gistFilesJSON("r1", map[string]githubGistFile{
+		"big.js": {Type: "application/javascript", Content: "the first megabyte", Truncated: true, RawURL: api.url + "/raw/missing.js"},
+	})
+	_, _, err = api.store(api.lockFile, true).content("def")
+	if err == nil || !strings.Contains(err.Error(), "could not be fetched from raw_url") {
+		t.Errorf("got %v, want an error for the raw_url", err)
+	}
+}
+
+func TestGistRateLimit(t *testing.T) {
+	almostUsedUp := gistJSON("r1", map[string]string{"a.js": "a"})
+	almostUsedUp.remaining = "3"
+	api := gistServer(t, map[string]apiResponse{
+		"/gists/abc": almostUsedUp,
+		"/gists/def": {status: http.StatusForbidden, body: `{"message":"API rate limit exceeded"}`, remaining: "0"},
+	})
+	_, warnings, err := api.store(api.lockFile, true).content("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "only 3 GitHub API requests are left") {
+		t.Errorf("got warnings %q, want a rate limit warning", warnings)
+	}
+	_, _, err = api.store(api.lockFile, true).content("def")
+	if err == nil || !strings.Contains(err.Error(), "rate limit is used up") {
+		t.Errorf("got %v, want a rate limit error", err)
+	}
+}
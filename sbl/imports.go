@@ -0,0 +1,196 @@
+package sbl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type importer struct {
+	// parsed files, by absolute path
+	files map[string]*SBLFile
+	// absolute paths of the files currently being loaded, used to detect cycles
+	loading []string
+}
+
+func (im *importer) load(filename string, bytes []byte) (*SBLFile, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	ast := &SBLFile{}
+	err = parser.ParseBytes(filename, bytes, ast)
+	if err != nil {
+		return nil, err
+	}
+	ast.path = path
+	im.files[path] = ast
+	im.loading = append(im.loading, path)
+	defer func() { im.loading = im.loading[:len(im.loading)-1] }()
+
+	for _, d := range ast.Declarations {
+		if d.Import == nil {
+			continue
+		}
+		// imports are relative to the importing file
+		importFilename := *d.Import
+		if !filepath.IsAbs(importFilename) {
+			importFilename = filepath.Join(filepath.Dir(filename), importFilename)
+		}
+		importPath, err := filepath.Abs(importFilename)
+		if err != nil {
+			return nil, errorAt(Range{d.Pos, d.EndPos}, "import", "import %q: %s", *d.Import, err.Error())
+		}
+		for i, loading := range im.loading {
+			if loading == importPath {
+				cycle := []string{}
+				for _, p := range append(im.loading[i:], importPath) {
+					cycle = append(cycle, filepath.Base(p))
+				}
+				return nil, errorAt(Range{d.Pos, d.EndPos}, "import-cycle", "import cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		imported := im.files[importPath]
+		if imported == nil {
+			bytes, err := os.ReadFile(importFilename)
+			if err != nil {
+				return nil, errorAt(Range{d.Pos, d.EndPos}, "import", "import %q: %s", *d.Import, err.Error())
+			}
+			imported, err = im.load(importFilename, bytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ast.imports = append(ast.imports, imported)
+	}
+	return ast, nil
+}
+
+// Imports returns the absolute paths of the files the file imports,
+// directly or through other files
+func (ast *SBLFile) Imports() []string {
+	paths := []string{}
+	for _, f := range ast.importedFiles() {
+		paths = append(paths, f.path)
+	}
+	return paths
+}
+
+// importedFiles returns the files imported by the file, directly or by the files it imports
+func (ast *SBLFile) importedFiles() []*SBLFile {
+	files := []*SBLFile{}
+	seen := map[*SBLFile]bool{ast: true}
+	var visit func(f *SBLFile)
+	visit = func(f *SBLFile) {
+		for _, imported := range f.imports {
+			if !seen[imported] {
+				seen[imported] = true
+				files = append(files, imported)
+				visit(imported)
+			}
+		}
+	}
+	visit(ast)
+	return files
+}
+
+// Names visible inside a file (aliases and blocks)
+type scope struct {
+	entry string
+	// the declaration of the entrypoint
+	entryRange Range
+	// aliases declared in the file itself, in declaration order
+	aliases []*Alias
+	// aliases declared in the file, or any file it imports
+	visible map[string]*Alias
+	// blocks declared in the file, or any file it imports
+	blocks map[string]*Block
+	// tests declared in the file itself
+	tests []*Test
+}
+
+// scope declares the names in the file and its imports.
+// The error is a Diagnostics with every invalid declaration.
+func (ast *SBLFile) scope() (*scope, error) {
+	s := &scope{
+		visible: make(map[string]*Alias),
+		blocks:  make(map[string]*Block),
+	}
+	diagnostics := ast.declare(s, true, make(map[*SBLFile]bool))
+	if diagnostics.HasErrors() {
+		return nil, diagnostics
+	}
+	return s, nil
+}
+
+// declare the names in the file and its imports,
+// only the root file can declare an entrypoint
+func (ast *SBLFile) declare(s *scope, root bool, declared map[*SBLFile]bool) Diagnostics {
+	var diagnostics Diagnostics
+	report := func(err error) {
+		diagnostics = append(diagnostics, DiagnosticFromError(err))
+	}
+	declared[ast] = true
+	for _, d := range ast.Declarations {
+		r := Range{d.Pos, d.EndPos}
+		if d.Entrypoint != nil {
+			if !root {
+				continue
+			}
+			if s.entry != "" {
+				report(withNotes(
+					errorAt(r, "duplicate-entry", "only one entrypoint can be specified per file"),
+					Note{s.entryRange, "previous entrypoint declared here"},
+				))
+				continue
+			}
+			s.entry = *d.Entrypoint
+			s.entryRange = r
+		} else if d.Alias != nil {
+			if other := s.visible[d.Alias.Name]; other != nil {
+				report(withNotes(
+					errorAt(r, "duplicate-alias", "duplicate alias definition: %s", d.Alias.Name),
+					Note{Range{other.Pos, other.EndPos}, "previously defined here"},
+				))
+				continue
+			}
+			s.visible[d.Alias.Name] = d.Alias
+			d.Alias.scope = s
+			if root {
+				s.aliases = append(s.aliases, d.Alias)
+			}
+		} else if d.Block != nil {
+			if other := s.blocks[d.Block.Name]; other != nil {
+				report(withNotes(
+					errorAt(r, "duplicate-block", "duplicate block definition: %s", d.Block.Name),
+					Note{Range{other.Pos, other.EndPos}, "previously defined here"},
+				))
+				continue
+			}
+			s.blocks[d.Block.Name] = d.Block
+		} else if d.Test != nil {
+			if !root {
+				continue
+			}
+			for _, other := range s.tests {
+				if other.Name == d.Test.Name {
+					report(withNotes(
+						errorAt(r, "duplicate-test", "duplicate test: %q", d.Test.Name),
+						Note{Range{other.Pos, other.EndPos}, "previously defined here"},
+					))
+				}
+			}
+			s.tests = append(s.tests, d.Test)
+		} else if d.Import == nil {
+			report(errorAt(r, "internal", "invalid declaration"))
+		}
+	}
+	for _, imported := range ast.imports {
+		if declared[imported] {
+			continue
+		}
+		diagnostics = append(diagnostics, imported.declare(s, false, declared)...)
+	}
+	return diagnostics
+}
@@ -0,0 +1,201 @@
+package sbl
+
+import (
+	"sort"
+)
+
+// Definition returns the declaration of the name at offset in the file (not its imports),
+// for the alias of a "call" action or an entrypoint, or the block of a "use" action.
+// Aliases and blocks can be declared in imported files.
+func (ast *SBLFile) Definition(offset int) (Range, bool) {
+	s := &scope{
+		visible: make(map[string]*Alias),
+		blocks:  make(map[string]*Block),
+	}
+	// duplicate declarations don't matter here, the first one is used
+	ast.declare(s, true, make(map[*SBLFile]bool))
+
+	var alias, block string
+	contains := func(start, end int) bool {
+		return offset >= start && offset < end
+	}
+	v := &actionVisitor{
+		simple: func(ea *ExecuteActionSimple) {
+			call := ea.CallAlias
+			if call != nil && call.User == nil && contains(call.Pos.Offset, call.EndPos.Offset) {
+				alias = call.AliasName
+			}
+		},
+		use: func(ub *UseBlockAction) {
+			if contains(ub.Pos.Offset, ub.EndPos.Offset) {
+				block = ub.BlockName
+			}
+		},
+	}
+	for _, d := range ast.Declarations {
+		if !contains(d.Pos.Offset, d.EndPos.Offset) {
+			continue
+		}
+		switch {
+		case d.Entrypoint != nil:
+			alias = *d.Entrypoint
+		case d.Alias != nil:
+			v.actions(d.Alias.Body.Actions)
+		case d.Block != nil:
+			v.actions(d.Block.Body.Actions)
+		case d.Test != nil:
+			for _, step := range d.Test.Steps {
+				if step.Run != nil && contains(step.Pos.Offset, step.EndPos.Offset) {
+					alias = step.Run.Alias
+				}
+			}
+		}
+	}
+	if a := s.visible[alias]; alias != "" && a != nil {
+		return Range{a.Pos, a.EndPos}, true
+	}
+	if b := s.blocks[block]; block != "" && b != nil {
+		return Range{b.Pos, b.EndPos}, true
+	}
+	return Range{}, false
+}
+
+// Keys returns the customData keys used with "get" and "set" in the file (not its imports), sorted
+func (ast *SBLFile) Keys() []string {
+	found := make(map[string]bool)
+	v := &actionVisitor{
+		retrieve: func(ra *RetrieveAction) {
+			if ra.RetrieveKey != nil {
+				found[*ra.RetrieveKey] = true
+			}
+		},
+		store: func(ca *ContinuedAction) {
+			found[*ca.StoreKey] = true
+		},
+	}
+	for _, d := range ast.Declarations {
+		switch {
+		case d.Alias != nil:
+			v.actions(d.Alias.Body.Actions)
+		case d.Block != nil:
+			v.actions(d.Block.Body.Actions)
+		}
+	}
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Gists returns the ids of the gists imported and injected by the file and its imports, sorted
+func (ast *SBLFile) Gists() []string {
+	found := make(map[string]bool)
+	v := &actionVisitor{
+		simple: func(ea *ExecuteActionSimple) {
+			if ea.JSExec == nil {
+				return
+			}
+			if ea.JSExec.ImportedGist != nil {
+				found[*ea.JSExec.ImportedGist] = true
+			}
+			for _, name := range ea.JSExec.InjectedGists {
+				if !isLocalModule(name) {
+					found[name] = true
+				}
+			}
+		},
+	}
+	files := append([]*SBLFile{ast}, ast.importedFiles()...)
+	for _, f := range files {
+		for _, d := range f.Declarations {
+			switch {
+			case d.Alias != nil:
+				v.actions(d.Alias.Body.Actions)
+			case d.Block != nil:
+				v.actions(d.Block.Body.Actions)
+			}
+		}
+	}
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// actionVisitor calls its functions for the parts of actions, and the actions nested in them.
+// Functions that are nil are not called.
+type actionVisitor struct {
+	retrieve func(*RetrieveAction)
+	simple   func(*ExecuteActionSimple)
+	// called for the parts of a chain that store a key
+	store func(*ContinuedAction)
+	use   func(*UseBlockAction)
+}
+
+func (v *actionVisitor) actions(actions []*AliasAction) {
+	for _, aa := range actions {
+		v.action(aa)
+	}
+}
+
+func (v *actionVisitor) action(aa *AliasAction) {
+	switch {
+	case aa.ExecuteAction != nil:
+		v.retrieveAction(aa.ExecuteAction.RetrieveAction)
+		v.simpleAction(aa.ExecuteAction.SimpleAction)
+		v.continued(aa.ExecuteAction.ContinueAction)
+	case aa.GetCompiledAction != nil:
+		if aa.GetCompiledAction.UseBlock != nil {
+			v.useBlock(aa.GetCompiledAction.UseBlock)
+		} else {
+			v.actions(aa.GetCompiledAction.CompilationRoot.Actions)
+		}
+		v.continued(aa.GetCompiledAction.ContinueAction)
+	case aa.IfAction != nil:
+		v.retrieveAction(aa.IfAction.RetrieveAction)
+		v.actions(aa.IfAction.Then)
+		v.actions(aa.IfAction.Else)
+		v.continued(aa.IfAction.ContinueAction)
+	case aa.DispatchAction != nil:
+		v.retrieveAction(aa.DispatchAction.Subject)
+		for _, dc := range aa.DispatchAction.Cases {
+			v.actions(dc.Actions)
+		}
+		v.continued(aa.DispatchAction.ContinueAction)
+	case aa.UseBlock != nil:
+		v.useBlock(aa.UseBlock)
+	case aa.OptionsAction != nil:
+		v.actions(aa.OptionsAction.Actions)
+	}
+}
+
+func (v *actionVisitor) retrieveAction(ra *RetrieveAction) {
+	if ra != nil && v.retrieve != nil {
+		v.retrieve(ra)
+	}
+}
+
+func (v *actionVisitor) simpleAction(ea *ExecuteActionSimple) {
+	if ea != nil && v.simple != nil {
+		v.simple(ea)
+	}
+}
+
+func (v *actionVisitor) useBlock(ub *UseBlockAction) {
+	if v.use != nil {
+		v.use(ub)
+	}
+}
+
+func (v *actionVisitor) continued(ca *ContinuedAction) {
+	for ; ca != nil; ca = ca.SecondContinue {
+		if ca.StoreKey != nil && v.store != nil {
+			v.store(ca)
+		}
+		v.simpleAction(ca.NextAction)
+	}
+}
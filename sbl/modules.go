@@ -0,0 +1,79 @@
+package sbl
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+// isLocalModule reports whether an injected name is a path to a javascript file, instead of a gist id.
+// Like ES module imports, local paths start with "./", "../" or "/".
+func isLocalModule(name string) bool {
+	return strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") || filepath.IsAbs(name)
+}
+
+// bundleJS bundles code with the local modules, which are resolved relative to filename.
+// The exports of the modules are available as globals in code, and the ones it doesn't use are removed.
+// The locations of messages in the modules have absolute file names.
+func bundleJS(filename string, code string, modules []string, loader esbuild.Loader, minify bool) (out []byte, errs, warnings []esbuild.Message, err error) {
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	inject := make([]string, len(modules))
+	for i, module := range modules {
+		if filepath.IsAbs(module) {
+			inject[i] = module
+		} else {
+			inject[i] = filepath.Join(dir, module)
+		}
+	}
+	res := esbuild.Build(esbuild.BuildOptions{
+		Stdin: &esbuild.StdinOptions{
+			Contents:   code,
+			ResolveDir: dir,
+			Sourcefile: filename,
+			Loader:     loader,
+		},
+		AbsWorkingDir: dir,
+		// the exports of injected files replace the globals with the same name
+		Inject:            inject,
+		Bundle:            true,
+		Format:            esbuild.FormatESModule,
+		Write:             false,
+		LogLevel:          esbuild.LogLevelSilent,
+		Drop:              esbuild.DropConsole,
+		IgnoreAnnotations: true,
+		TreeShaking:       esbuild.TreeShakingTrue,
+		MinifyWhitespace:  true,
+		MinifyIdentifiers: minify,
+		MinifySyntax:      minify,
+	})
+	// locations are relative to the working directory, except for code
+	for _, messages := range [][]esbuild.Message{res.Errors, res.Warnings} {
+		for _, m := range messages {
+			for _, l := range append([]*esbuild.Location{m.Location}, noteLocations(m.Notes)...) {
+				if l != nil && l.File != filename && !filepath.IsAbs(l.File) {
+					l.File = filepath.Join(dir, l.File)
+				}
+			}
+		}
+	}
+	if len(res.Errors) > 0 {
+		return nil, res.Errors, res.Warnings, nil
+	}
+	if len(res.OutputFiles) != 1 {
+		return nil, nil, nil, errors.New("esbuild did not output one file")
+	}
+	return res.OutputFiles[0].Contents, nil, res.Warnings, nil
+}
+
+func noteLocations(notes []esbuild.Note) []*esbuild.Location {
+	locations := make([]*esbuild.Location, len(notes))
+	for i, n := range notes {
+		locations[i] = n.Location
+	}
+	return locations
+}
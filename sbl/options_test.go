@@ -0,0 +1,45 @@
+package sbl_test
+
+import (
+	"testing"
+
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+func TestOptionValues(t *testing.T) {
+	src := "alias xx with maxlength=0 lengtherror=true split=false minify=false\n\texec \"ping\"\nend\n"
+	ast, err := sbl.Parse("test.sbl", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sbl.Compile(ast, sbl.Options{MaxLength: 5}); err != nil {
+		t.Errorf("maxlength=0 did not remove the limit: %v", err)
+	}
+	formatted, err := sbl.Format("test.sbl", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(formatted) != src {
+		t.Errorf("formatted to %q", formatted)
+	}
+}
+
+func TestOptionsBlockInvalid(t *testing.T) {
+	for name, body := range map[string]string{
+		"alias only":   "\toptions split=true\n\t\texec \"ping\"\n\tend\n",
+		"get compiled": "\tget compiled\n\t\toptions argliterals=true\n\t\t\texec \"ping ${0}\"\n\t\tend\n\tend -> exec \"pipe\"\n",
+	} {
+		ast, err := sbl.Parse("test.sbl", []byte("alias xx\n"+body+"end\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := sbl.Compile(ast, sbl.Options{})
+		if err == nil {
+			t.Errorf("%s: compiled %q, want an error", name, result.Aliases)
+			continue
+		}
+		if d := result.Diagnostics; len(d) != 1 || d[0].Code != "invalid-option" {
+			t.Errorf("%s: got %v, want an invalid-option error", name, d)
+		}
+	}
+}
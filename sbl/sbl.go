@@ -0,0 +1,215 @@
+// Package sbl parses SBL (Supibot Language) files, and compiles them to supibot aliases.
+package sbl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Parse a file, and every file it imports (relative to filename)
+func Parse(filename string, src []byte) (*SBLFile, error) {
+	im := &importer{files: make(map[string]*SBLFile)}
+	return im.load(filename, src)
+}
+
+// ParseFile reads and parses a file, and every file it imports
+func ParseFile(filename string) (*SBLFile, error) {
+	bytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filename, bytes)
+}
+
+type Options struct {
+	// Name of the alias to compile, instead of the entrypoint of the file
+	Entry string
+	// Compile every alias in the file (and the imported aliases they call),
+	// instead of only the entrypoint
+	All bool
+	// Also compile the aliases the entrypoint calls, and the ones they call, before it
+	Called bool
+	// Maximum length of an alias, 0 means no limit.
+	// Aliases can set their own limit with the maxlength option.
+	MaxLength int
+	// Fail instead of warning when an alias is longer than MaxLength
+	LengthError bool
+	// Split aliases that are longer than MaxLength into several aliases,
+	// named like "alias__part2". Aliases can also use the split option.
+	Split bool
+	// Path of the lock file for the gists, by default LockFileName next to the compiled file
+	LockFile string
+	// Add the gists that are not in the lock file to it, instead of only
+	// fetching their latest revision, so that the next build uses the same one
+	WriteLock bool
+	// Directory gists are cached in, DefaultCacheDir() if it is empty
+	CacheDir string
+	// Fail when a gist is not cached, instead of fetching it
+	Offline bool
+	// Fetches the gists that are not cached, from https://api.github.com if it is nil
+	Fetcher Fetcher
+}
+
+type CompiledAlias struct {
+	Name string
+	// "$alias addedit" command that defines the alias
+	Code string
+	// Length of Code, in the characters counted by supibot
+	Length int
+	// How much each top level action and js block adds to the length
+	Parts []LengthPart
+}
+
+type Result struct {
+	// The compiled aliases, an alias always comes after the aliases it calls
+	Aliases     []CompiledAlias
+	Diagnostics Diagnostics
+}
+
+// Code returns the commands that define every alias, one per line
+func (r *Result) Code() string {
+	lines := make([]string, 0, len(r.Aliases))
+	for _, alias := range r.Aliases {
+		lines = append(lines, alias.Code)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Compile the aliases in a parsed file. The error is the Diagnostics of the
+// result if any of them are errors, no aliases are returned in that case.
+func Compile(ast *SBLFile, opts Options) (*Result, error) {
+	result := &Result{}
+	s, err := ast.scope()
+	if err != nil {
+		result.Diagnostics = DiagnosticsFromError(err)
+		return result, result.Diagnostics
+	}
+	if opts.LockFile == "" {
+		opts.LockFile = filepath.Join(filepath.Dir(ast.path), LockFileName)
+	}
+	c := &compilation{scope: s, options: opts, result: result, compiled: make(map[*Alias][]CompiledAlias)}
+	if opts.All {
+		c.compileAll()
+	} else {
+		c.compileEntry(opts.Entry)
+	}
+	if result.Diagnostics.HasErrors() {
+		result.Aliases = nil
+		return result, result.Diagnostics
+	}
+	return result, nil
+}
+
+type compilation struct {
+	scope   *scope
+	options Options
+	result  *Result
+	// an alias that was split compiles to several aliases, the alias itself is last
+	compiled map[*Alias][]CompiledAlias
+}
+
+// compile an alias once, reporting its diagnostics
+func (c *compilation) compile(alias *Alias) error {
+	if _, ok := c.compiled[alias]; ok {
+		return nil
+	}
+	compiled, diagnostics, err := alias.compile(c.options)
+	c.compiled[alias] = compiled
+	c.result.Diagnostics = append(c.result.Diagnostics, diagnostics...)
+	if err != nil && !errors.Is(err, errReported) {
+		c.result.Diagnostics = append(c.result.Diagnostics, DiagnosticFromError(err))
+	}
+	return err
+}
+
+func (c *compilation) add(alias *Alias) {
+	c.result.Aliases = append(c.result.Aliases, c.compiled[alias]...)
+}
+
+func (c *compilation) compileEntry(entry string) {
+	s := c.scope
+	var alias *Alias
+	if entry != "" {
+		alias = s.visible[entry]
+		if alias == nil {
+			c.fail(errorAt(Range{}, "unknown-alias", "unknown alias: %s", entry))
+			return
+		}
+	} else if s.entry == "" && len(s.aliases) == 1 {
+		alias = s.aliases[0]
+	} else if s.entry == "" {
+		c.fail(errorAt(Range{}, "no-entrypoint", "entrypoint can only be omitted if there is one alias"))
+		return
+	} else if s.visible[s.entry] != nil {
+		alias = s.visible[s.entry]
+	} else {
+		c.fail(errorAt(s.entryRange, "unknown-alias", "unknown alias: %s", s.entry))
+		return
+	}
+	if c.compile(alias) != nil {
+		return
+	}
+	if c.options.Called {
+		c.addCalled(alias, make(map[string]bool))
+	} else {
+		c.add(alias)
+	}
+}
+
+// Compile every alias in the file, one "$alias addedit" line per alias.
+// Imported aliases are included when they are called.
+// Aliases are ordered so that an alias comes after the aliases it calls.
+func (c *compilation) compileAll() {
+	s := c.scope
+	if len(s.aliases) == 0 {
+		c.fail(errorAt(Range{}, "no-aliases", "there are no aliases in this file"))
+		return
+	}
+
+	// compile everything first, so blocks that use themselves are reported
+	// before looking for calls inside them
+	failed := false
+	for _, alias := range s.aliases {
+		if c.compile(alias) != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return
+	}
+
+	visited := make(map[string]bool)
+	for _, alias := range s.aliases {
+		if c.addCalled(alias, visited) != nil {
+			return
+		}
+	}
+}
+
+// addCalled adds a compiled alias, after the aliases it calls that are not visited yet.
+// It is depth first, so dependencies are added before their callers,
+// calls that form a cycle fall back to declaration order.
+func (c *compilation) addCalled(alias *Alias, visited map[string]bool) error {
+	if visited[alias.Name] {
+		return nil
+	}
+	visited[alias.Name] = true
+	for _, name := range alias.Body.calledAliases(c.scope.blocks) {
+		if dependency := c.scope.visible[name]; dependency != nil {
+			if err := c.compile(dependency); err != nil {
+				return err
+			}
+			if err := c.addCalled(dependency, visited); err != nil {
+				return err
+			}
+		}
+	}
+	c.add(alias)
+	return nil
+}
+
+func (c *compilation) fail(err error) {
+	c.result.Diagnostics = append(c.result.Diagnostics, DiagnosticFromError(err))
+}
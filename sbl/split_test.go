@@ -0,0 +1,81 @@
+package sbl_test
+
+import (
+	"testing"
+
+	"github.com/notnotquinn/supilang/emulator"
+	"github.com/notnotquinn/supilang/emulator/jsengine"
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+func newEmulator() *emulator.Emulator {
+	e := emulator.New()
+	e.JS = jsengine.New()
+	return e
+}
+
+// runTests runs the tests in src, failing t for every test that fails
+func runTests(t *testing.T, src string, opts sbl.Options) *sbl.TestReport {
+	t.Helper()
+	ast, err := sbl.Parse("test.sbl", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := sbl.RunTests(ast, opts, newEmulator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range report.Tests {
+		if !test.Passed() {
+			t.Errorf("test %q failed: %s", test.Name, test.Failures.Error())
+		}
+	}
+	return report
+}
+
+func TestSplitKeepsArguments(t *testing.T) {
+	src := `
+alias xx
+	${0+} -> say -> set temp "in"
+	exec "abb say one" -> set temp "one"
+	exec "abb say two" -> set temp "two"
+	exec "abb say three" -> set temp "three"
+	get "in" -> js ` + "```" + `return args.join(" ") + "!"` + "```" + `
+end
+
+test "split keeps the arguments"
+	run xx "hello" "there"
+	expect output "hello there!"
+	expect unset "in"
+end
+`
+	runTests(t, src, sbl.Options{})
+	report := runTests(t, src, sbl.Options{MaxLength: 200, Split: true})
+	split := false
+	for _, d := range report.Diagnostics {
+		if d.Code == "split" {
+			split = true
+		}
+	}
+	if !split {
+		t.Error("the alias was not split")
+	}
+}
+
+func TestSplitPassesArgumentsToLaterParts(t *testing.T) {
+	src := `
+alias xx
+	exec "abb say one" -> set temp "one"
+	exec "abb say two" -> set temp "two"
+	exec "abb say three" -> set temp "three"
+	${1} -> say
+end
+
+test "the last part gets the arguments"
+	run xx "hello" "there"
+	expect output "there"
+end
+`
+	runTests(t, src, sbl.Options{})
+	runTests(t, src, sbl.Options{MaxLength: 150, Split: true})
+}
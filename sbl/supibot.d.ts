@@ -0,0 +1,38 @@
+// Globals of the function of supibot's $js command, and the functions the compiler injects.
+// Functions are the body of a function, so they can return their output.
+
+/** The arguments of the command, split on spaces */
+declare const args: string[];
+/** Name of the user that ran the command */
+declare const executor: string;
+/** Name of the channel the command was run in, null in whispers */
+declare const channel: string | null;
+
+/** Values kept between runs for the executor, values must be JSON */
+declare const customData: {
+	/** Returns the value of the key, or undefined if it is not set */
+	get(key: string): any;
+	/** Sets the value of the key, undefined unsets it */
+	set(key: string, value: any): void;
+};
+
+/** Some of the helpers supibot provides */
+declare const utils: {
+	/** Random integer from min to max, including both */
+	random(min: number, max: number): number;
+	/** Random element of the array, undefined if it is empty */
+	randArray<T>(array: T[]): T | undefined;
+	/** Makes the first letter uppercase */
+	capitalize(text: string): string;
+	/** Pads the number with zeros to the length */
+	zf(number: number, length: number): string;
+	/** Cuts the text to the length, ending with "…" if it was cut */
+	wrapString(text: string, length: number): string;
+};
+
+/** Gets the value of the key, with the key prefix of the alias */
+declare function getLocal(key: string): any;
+/** Sets the value of the key, with the key prefix of the alias */
+declare function setLocal(key: string, value: any): void;
+/** Returns the key prefix of the alias */
+declare function getLocalPrefix(): string;
@@ -0,0 +1,258 @@
+package sbl
+
+import (
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+type SBLFile struct {
+	Declarations []Declaration `@@*`
+	// files imported by this file (see Parse)
+	imports []*SBLFile
+	// absolute path of the file
+	path string
+}
+
+type Declaration struct {
+	Pos        lexer.Position
+	EndPos     lexer.Position
+	Entrypoint *string `  "entry" @Ident`
+	Import     *string `|  "import" @String`
+	Alias      *Alias  `|  @@`
+	Block      *Block  `|  @@`
+	Test       *Test   `|  @@`
+}
+
+type Alias struct {
+	Pos       lexer.Position
+	EndPos    lexer.Position
+	Name      string     `  "alias" @Ident`
+	Keyprefix *string    `[ "prefixed" @String ]`
+	Options   []*Option  `[ "with" @@+ ]`
+	Body      *AliasBody `   @@`
+	// names visible to the alias (see SBLFile.scope)
+	scope *scope
+}
+
+// Changes one of the AliasOptions, eg. "minify=false"
+type Option struct {
+	Pos    lexer.Position
+	EndPos lexer.Position
+	Name   string `@Ident "="`
+	Value  string `@(Ident | Number)`
+}
+
+// Actions that can be reused in aliases
+type Block struct {
+	Pos    lexer.Position
+	EndPos lexer.Position
+	Name   string     `"block" @Ident`
+	Body   *AliasBody `@@`
+}
+
+type AliasBody struct {
+	Pos     lexer.Position
+	EndPos  lexer.Position
+	Actions []*AliasAction `@@* "end"`
+}
+
+type AliasAction struct {
+	Pos               lexer.Position
+	EndPos            lexer.Position
+	ExecuteAction     *ExecuteAction     `  @@`
+	GetCompiledAction *GetCompiledAction `| @@` // possibly refactor into retrieve action
+	IfAction          *IfAction          `| @@`
+	DispatchAction    *DispatchAction    `| @@`
+	UseBlock          *UseBlockAction    `| @@`
+	OptionsAction     *OptionsAction     `| @@`
+}
+
+// Compile actions with different options
+type OptionsAction struct {
+	Pos     lexer.Position
+	EndPos  lexer.Position
+	Options []*Option      `"options" @@+`
+	Actions []*AliasAction `@@* "end"`
+}
+
+// Expand a block in place of this action
+type UseBlockAction struct {
+	Pos       lexer.Position
+	EndPos    lexer.Position
+	BlockName string `"use" @Ident`
+}
+
+// Execute one of two blocks, depending on a javascript expression
+type IfAction struct {
+	Pos            lexer.Position
+	EndPos         lexer.Position
+	RetrieveAction *RetrieveAction  `[ @@ "->" ]`
+	Condition      JSExecString     `  "if" @@`
+	Then           []*AliasAction   `  @@*`
+	Else           []*AliasAction   `[ "else" @@* ]`
+	ContinueAction *ContinuedAction `  "end" [ "->" @@ ]`
+}
+
+// Execute a command, storing the output for later use
+type ExecuteAction struct {
+	RetrieveAction *RetrieveAction      `[ @@ "->" ]`
+	SimpleAction   *ExecuteActionSimple `  @@`
+	ContinueAction *ContinuedAction     `[ "->" @@ ]`
+}
+
+type RetrieveAction struct {
+	Pos              lexer.Position
+	EndPos           lexer.Position
+	LocalRetrieveKey bool    `  "get" [ @"local" ] `
+	RetrieveKey      *string `   @String`
+	RetrieveArgs     *string `|  @ArgLiteral`
+}
+
+// Execute the block whose case matches a value
+type DispatchAction struct {
+	Pos            lexer.Position
+	EndPos         lexer.Position
+	Subject        *RetrieveAction  `  "dispatch" @@`
+	Cases          []*DispatchCase  `  @@*`
+	ContinueAction *ContinuedAction `  "end" [ "->" @@ ]`
+}
+
+type DispatchCase struct {
+	Pos     lexer.Position
+	EndPos  lexer.Position
+	Values  []string       `(  "case" @String { "," @String }`
+	Empty   bool           ` | @"empty"`
+	Default bool           ` | @"default" )`
+	Actions []*AliasAction `@@*`
+}
+
+type ContinuedAction struct {
+	StoreKeyTemp   bool                 `   "set" [ @"temp" ]`
+	StoreKeyLocal  bool                 `   [ @"local" ]`
+	StoreKey       *string              `   @String`
+	NextAction     *ExecuteActionSimple `|  @@`
+	SecondContinue *ContinuedAction     `[ "->" @@ ]`
+}
+
+// Execute a command, voiding the output
+type ExecuteActionSimple struct {
+	Pos                 lexer.Position
+	EndPos              lexer.Position
+	JSExec              *JSExecAction    `  "js" @@`
+	PipeCommandLiterals []string         `|  ("exec" | "pipe") @String { "|" @String } `
+	UseSayLiteral       bool             `|  @"say" `
+	SayLiteral          *string          `   [ @String ] `
+	CallAlias           *CallAliasAction `|  @@`
+}
+
+type JSExecAction struct {
+	Pos           lexer.Position
+	EndPos        lexer.Position
+	ImportedGist  *string      `[ "import" @String ]`
+	InjectedGists []string     `[ "inject" @String { "," @String } ]`
+	ExecString    JSExecString `@@`
+	// javascript placed directly before and after ExecString (for generated actions)
+	prefix, suffix string
+}
+
+type JSExecString struct {
+	Pos       lexer.Position
+	EndPos    lexer.Position
+	RawString string `@JSExecString`
+}
+
+type GetCompiledAction struct {
+	UseBlock        *UseBlockAction  `"get" "compiled" ( @@`
+	CompilationRoot *AliasBody       `                 | @@ )`
+	ContinueAction  *ContinuedAction `[ "->" @@ ]`
+}
+
+type CallAliasAction struct {
+	Pos       lexer.Position
+	EndPos    lexer.Position
+	User      *string `"call" [ @User ]`
+	AliasName string  `@Ident`
+}
+
+// A test of the aliases in the file, the steps are run in order
+type Test struct {
+	Pos    lexer.Position
+	EndPos lexer.Position
+	Name   string      `"test" @String`
+	Steps  []*TestStep `@@* "end"`
+}
+
+type TestStep struct {
+	Pos    lexer.Position
+	EndPos lexer.Position
+	// run an alias with arguments
+	Run *TestRun `  @@`
+	// set the executor for the following runs
+	Executor *string `| "as" @String`
+	// set the channel for the following runs
+	Channel *string `| "in" @String`
+	// set a customData key of the executor
+	Data *TestData `| "data" @@`
+	// make a command output some text
+	Stub   *TestStub   `| "stub" @@`
+	Expect *TestExpect `| "expect" @@`
+}
+
+type TestRun struct {
+	Alias string   `"run" @Ident`
+	Args  []string `@String*`
+}
+
+type TestData struct {
+	Key   string `@String "="`
+	Value string `@String`
+}
+
+type TestStub struct {
+	Command string `@String`
+	Output  string `@String`
+}
+
+// Checks the result of the last run
+type TestExpect struct {
+	Output *string   `  "output" @String`
+	Data   *TestData `| "data" @@`
+	// the key is not set
+	Unset *string `| "unset" @String`
+	// the run failed, with an error that contains the text
+	Error *string `| "error" @String`
+}
+
+// Custom lexer for Aliases
+var aliasLexer = lexer.MustSimple([]lexer.Rule{
+	// identifiers can "overwrite" keywords, otherwise keywords are priorotized
+	{`Ident`, `[-a-zA-Z_0-9]{2,30}`, nil},
+	// single digits, longer numbers are identifiers
+	{`Number`, `\d`, nil},
+	{`Keyword`, `alias|import|inject|local|end|exec|pipe|prefixed|js|say|get|set|compiled|call|say|entry|if|else|dispatch|case|empty|default|block|use|with|options|=|\||->|,`, nil},
+	{`User`, `@[-a-zA-Z_0-9]*`, nil},
+	{`ArgLiteral`, `\${(\d+\+?|-?\d+|-?\d+\.\.(-?\d+)?|\d+-\d+|executor|channel)}`, nil},
+	{`JSExecString`, `(\x60{3})(?:\\.|[^\x60])*(\x60{3})`, nil},
+	// {`Word`, `[a-zA-Z_][a-zA-Z0-9_]`, nil},
+	{`String`, `"(?:\\.|[^"])*"`, nil},
+	// comments are kept by the lexer for Format, but the parser ignores them
+	{"Comment", `#[^\n]*`, nil},
+	{"Whitespace", `\s+`, nil},
+})
+
+var parser = participle.MustBuild(&SBLFile{},
+	participle.Lexer(aliasLexer),
+	participle.UseLookahead(4),
+	participle.Elide("Comment", "Whitespace"),
+	participle.Unquote("String"),
+	processToken(0, 3, false, "JSExecString"),
+)
+
+// What do I even want in this language?
+// ...
+
+// Define aliases - abstract some things away and make it easier to design them
+
+// What will help me with this goal?
+
+// Abstract COMMON paterns in aliases, make them easier to edit and read
@@ -0,0 +1,169 @@
+package sbl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/notnotquinn/supilang/emulator"
+)
+
+// Executor of the runs in a test, unless it is changed with "as"
+const defaultTestExecutor = "tester"
+
+type TestResult struct {
+	Name  string
+	Range Range
+	// Why the test failed, empty if it passed
+	Failures Diagnostics
+}
+
+func (r TestResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+type TestReport struct {
+	Tests []TestResult
+	// Diagnostics reported while compiling the aliases
+	Diagnostics Diagnostics
+}
+
+// Passed reports whether every test passed
+func (r *TestReport) Passed() bool {
+	for _, t := range r.Tests {
+		if !t.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+// RunTests compiles every alias visible in the file, and runs the tests of the file.
+// Each test runs in a new emulator from newEmulator.
+// The error is the Diagnostics of the report if the aliases could not be compiled.
+func RunTests(ast *SBLFile, opts Options, newEmulator func() *emulator.Emulator) (*TestReport, error) {
+	report := &TestReport{}
+	s, err := ast.scope()
+	if err != nil {
+		report.Diagnostics = DiagnosticsFromError(err)
+		return report, report.Diagnostics
+	}
+	result := &Result{}
+	c := &compilation{scope: s, options: opts, result: result, compiled: make(map[*Alias][]CompiledAlias)}
+	names := make([]string, 0, len(s.visible))
+	for name := range s.visible {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if c.compile(s.visible[name]) == nil {
+			c.add(s.visible[name])
+		}
+	}
+	report.Diagnostics = result.Diagnostics
+	if report.Diagnostics.HasErrors() {
+		return report, report.Diagnostics
+	}
+
+	for _, test := range s.tests {
+		report.Tests = append(report.Tests, runTest(test, result.Aliases, newEmulator()))
+	}
+	return report, nil
+}
+
+// runTest runs the steps of a test, defining the aliases for each executor that runs them
+func runTest(test *Test, aliases []CompiledAlias, e *emulator.Emulator) TestResult {
+	result := TestResult{Name: test.Name, Range: Range{test.Pos, test.EndPos}}
+	fail := func(step *TestStep, format string, args ...interface{}) {
+		result.Failures = append(result.Failures, DiagnosticFromError(
+			errorAt(Range{step.Pos, step.EndPos}, "test-failed", format, args...),
+		))
+	}
+
+	executor := defaultTestExecutor
+	channel := ""
+	defined := make(map[string]bool)
+	var output string
+	var runErr error
+	// the step of the last run, while its error has not been expected
+	var unexpectedErr *TestStep
+	ran := false
+	for _, step := range test.Steps {
+		switch {
+		case step.Run != nil:
+			if unexpectedErr != nil {
+				fail(unexpectedErr, "run failed: %s", runErr)
+			}
+			if !defined[executor] {
+				for _, alias := range aliases {
+					if _, err := e.Run(executor, channel, alias.Code); err != nil {
+						fail(step, "define alias %s: %s", alias.Name, err)
+					}
+				}
+				defined[executor] = true
+			}
+			message := "$$" + step.Run.Alias
+			if len(step.Run.Args) > 0 {
+				message += " " + strings.Join(step.Run.Args, " ")
+			}
+			output, runErr = e.Run(executor, channel, message)
+			unexpectedErr = nil
+			if runErr != nil {
+				unexpectedErr = step
+			}
+			ran = true
+		case step.Executor != nil:
+			executor = *step.Executor
+		case step.Channel != nil:
+			channel = *step.Channel
+		case step.Data != nil:
+			e.User(executor).CustomData[step.Data.Key] = step.Data.Value
+		case step.Stub != nil:
+			e.Register(step.Stub.Command, emulator.Reply(step.Stub.Output))
+		case step.Expect != nil:
+			if !ran {
+				fail(step, "expect must come after run")
+				continue
+			}
+			expectStep(step, output, runErr, e.User(executor).CustomData, fail)
+			if step.Expect.Error != nil {
+				unexpectedErr = nil
+			}
+		}
+	}
+	if unexpectedErr != nil {
+		fail(unexpectedErr, "run failed: %s", runErr)
+	}
+	if !ran {
+		fail(&TestStep{Pos: test.Pos, EndPos: test.EndPos}, "test %q does not run an alias", test.Name)
+	}
+	return result
+}
+
+// expectStep checks the result of a run
+func expectStep(step *TestStep, output string, runErr error, customData map[string]interface{}, fail func(step *TestStep, format string, args ...interface{})) {
+	expect := step.Expect
+	switch {
+	case expect.Output != nil:
+		if runErr == nil && output != *expect.Output {
+			fail(step, "expected output %q, got %q", *expect.Output, output)
+		}
+	case expect.Data != nil:
+		value, ok := customData[expect.Data.Key]
+		if !ok {
+			fail(step, "expected key %q to be %q, but it is not set", expect.Data.Key, expect.Data.Value)
+		} else if fmt.Sprint(value) != expect.Data.Value {
+			fail(step, "expected key %q to be %q, got %q", expect.Data.Key, expect.Data.Value, fmt.Sprint(value))
+		}
+	case expect.Unset != nil:
+		if value, ok := customData[*expect.Unset]; ok {
+			fail(step, "expected key %q to not be set, got %q", *expect.Unset, fmt.Sprint(value))
+		}
+	case expect.Error != nil:
+		if runErr == nil {
+			fail(step, "expected the run to fail, got output %q", output)
+		} else if !strings.Contains(runErr.Error(), *expect.Error) {
+			fail(step, "expected an error containing %q, got %q", *expect.Error, runErr.Error())
+		}
+	}
+}
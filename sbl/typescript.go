@@ -0,0 +1,21 @@
+package sbl
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// SandboxTypes is a TypeScript declaration file for the globals of $js functions,
+// and the functions the compiler injects, for editors and type checkers
+//
+//go:embed supibot.d.ts
+var SandboxTypes string
+
+// typeScriptBlock reports whether code is a "```ts" block. The "ts" is replaced with spaces,
+// so the columns of the code stay the same.
+func typeScriptBlock(code string) (string, bool) {
+	if strings.HasPrefix(code, "ts\n") || strings.HasPrefix(code, "ts\r\n") {
+		return "  " + code[2:], true
+	}
+	return code, false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/notnotquinn/supilang/sbl"
+)
+
+// How often the watched files are checked for changes
+const watchInterval = 500 * time.Millisecond
+
+// watcher compiles the .sbl files in a directory when they, or the files they import, change.
+// Each file is written next to it, with the extension .alias instead of .sbl.
+type watcher struct {
+	dir     string
+	options sbl.Options
+	report  func(sbl.Diagnostics)
+	// modification time of every file that is watched, including imported files outside dir
+	modTimes map[string]time.Time
+	// absolute paths of the files each file imports, from the last time it was parsed
+	imports map[string][]string
+	// length of each alias compiled from a file, by alias name
+	lengths map[string]map[string]int
+}
+
+// watch compiles every file in dir, and then the files that change, until the program is stopped
+func watch(dir string, options sbl.Options, report func(sbl.Diagnostics)) error {
+	w := &watcher{
+		dir:      dir,
+		options:  options,
+		report:   report,
+		modTimes: make(map[string]time.Time),
+		imports:  make(map[string][]string),
+		lengths:  make(map[string]map[string]int),
+	}
+	first := true
+	for {
+		files, err := w.files()
+		if err != nil {
+			return err
+		}
+		changed := w.changed(files)
+		for _, file := range files {
+			if first || w.affected(file, changed) {
+				w.compile(file)
+			}
+		}
+		if first {
+			fmt.Fprintf(os.Stderr, "watching %s for changes\n", dir)
+			first = false
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
+// files returns the absolute paths of the .sbl files in the directory, skipping hidden directories
+func (w *watcher) files() ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(w.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != w.dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".sbl" {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			files = append(files, abs)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// changed returns the watched files that were created, modified or removed since the last check
+func (w *watcher) changed(files []string) map[string]bool {
+	watched := make(map[string]bool)
+	for _, file := range files {
+		watched[file] = true
+		for _, imported := range w.imports[file] {
+			watched[imported] = true
+		}
+	}
+	for file := range w.modTimes {
+		watched[file] = true
+	}
+
+	changed := make(map[string]bool)
+	for file := range watched {
+		info, err := os.Stat(file)
+		if err != nil {
+			if _, ok := w.modTimes[file]; ok {
+				delete(w.modTimes, file)
+				changed[file] = true
+			}
+			continue
+		}
+		if modTime, ok := w.modTimes[file]; !ok || !modTime.Equal(info.ModTime()) {
+			w.modTimes[file] = info.ModTime()
+			changed[file] = true
+		}
+	}
+	return changed
+}
+
+// affected reports whether the file, or a file it imports, changed
+func (w *watcher) affected(file string, changed map[string]bool) bool {
+	if changed[file] {
+		return true
+	}
+	for _, imported := range w.imports[file] {
+		if changed[imported] {
+			return true
+		}
+	}
+	return false
+}
+
+// compile a file, printing its diagnostics and how the length of each alias changed
+func (w *watcher) compile(file string) {
+	name := file
+	if dir, err := filepath.Abs(w.dir); err == nil {
+		if rel, err := filepath.Rel(dir, file); err == nil {
+			name = rel
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s %s\n", time.Now().Format("15:04:05"), name)
+	ast, err := sbl.ParseFile(file)
+	if err != nil {
+		w.report(sbl.Diagnostics{sbl.DiagnosticFromError(err)})
+		return
+	}
+	w.imports[file] = ast.Imports()
+	result, err := sbl.Compile(ast, w.options)
+	w.report(result.Diagnostics)
+	if err != nil {
+		return
+	}
+
+	lengths := make(map[string]int)
+	for _, alias := range result.Aliases {
+		lengths[alias.Name] = alias.Length
+		delta := ""
+		if previous, ok := w.lengths[file][alias.Name]; ok && previous != alias.Length {
+			delta = fmt.Sprintf(" (%+d)", alias.Length-previous)
+		}
+		fmt.Fprintf(os.Stderr, "\t%s: %d characters%s\n", alias.Name, alias.Length, delta)
+	}
+	w.lengths[file] = lengths
+
+	output := strings.TrimSuffix(file, ".sbl") + ".alias"
+	if err := os.WriteFile(output, []byte(result.Code()+"\n"), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}